@@ -0,0 +1,3 @@
+// Package api serves the wallet and chain of a node over HTTP as JSON, and
+// over RPC to the command line client.
+package api
@@ -0,0 +1,713 @@
+package api
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/rpc"
+	"os"
+	"strings"
+	"time"
+
+	"minhlpc/build_blockchain/core"
+	"minhlpc/build_blockchain/p2p"
+	"minhlpc/build_blockchain/wallet"
+)
+
+// The RPC service lets the command line client use a running node instead of
+// opening the node database, which only one process can hold, and reach the
+// wallets the node has loaded. It listens on a free localhost port, and the
+// node writes that address together with a random cookie to a file next to
+// its wallets. Clients send the cookie as the first line of a connection, so
+// only users who can read the node files get in.
+
+const (
+	rpcCookieLength = 32
+	rpcAuthTimeout  = 5 * time.Second
+)
+
+var ErrNodeNotRunning = errors.New("node is not running, start it with startnode")
+
+func rpcCookieFile(nodeId string) string {
+	return core.NetPath(".", fmt.Sprintf("node_%s.rpc", nodeId))
+}
+
+// Node is the RPC service of a node, registered under the name "Node"
+type Node struct {
+	chain   *core.BlockChain
+	node    *p2p.Node
+	nodeId  string
+	manager *wallet.Manager
+}
+
+type Empty struct{}
+
+type BalanceArgs struct {
+	Address string
+	Wallet  string
+}
+
+type BalanceReply struct {
+	Balance       int
+	ChangeBalance int
+}
+
+// SendArgs pays Amounts from the key of From in Wallet. Strategy and Inputs
+// take the same text as the command line flags.
+type SendArgs struct {
+	From        string
+	Amounts     map[string]int
+	Wallet      string
+	Mine        bool
+	ReuseChange bool
+	Strategy    string
+	Inputs      string
+}
+
+// RawTxArgs carries the payment of CreateRawTx, or in Tx the transaction
+// SignRawTx signs with the keys of Wallet and SendRawTx sends
+type RawTxArgs struct {
+	From     string
+	Amounts  map[string]int
+	Change   string
+	Strategy string
+	Inputs   string
+	Tx       string
+	Wallet   string
+	Mine     bool
+}
+
+type TxReply struct {
+	TxID string
+	Tx   string
+}
+
+type UnspentReply struct {
+	Outputs []core.SpendableOutput
+}
+
+type TransactionsArgs struct {
+	Address string
+	Wallet  string
+	Skip    int
+	Count   int
+}
+
+type TransactionsReply struct {
+	Entries []core.TxHistoryEntry
+}
+
+type BlocksReply struct {
+	Blocks []core.Block
+}
+
+type ReindexReply struct {
+	Transactions int
+}
+
+// SetBanArgs bans Host for Duration, or lifts its ban when Remove is set
+type SetBanArgs struct {
+	Host     string
+	Duration time.Duration
+	Remove   bool
+}
+
+type BannedReply struct {
+	Bans []p2p.Ban
+}
+
+// WalletArgs picks a wallet by Name, or with the empty name the one of a
+// -wallet flag in Wallet. CreateWallet makes the named wallet, or adds an
+// address to Wallet when Name is empty.
+type WalletArgs struct {
+	Name   string
+	Wallet string
+	Bech32 bool
+}
+
+type AddressReply struct {
+	Address string
+}
+
+type WalletsReply struct {
+	Names  []string
+	Loaded []string
+}
+
+// AddressInfo is an address of a wallet with its label, and the address it
+// is change for if it is a change address
+type AddressInfo struct {
+	Address  string
+	Label    string
+	ChangeOf string
+}
+
+type AddressesReply struct {
+	Addresses []AddressInfo
+}
+
+// LabelArgs names an address of Wallet or a contact, the empty Label removes
+// the label
+type LabelArgs struct {
+	Address string
+	Label   string
+	Wallet  string
+}
+
+type ContactsReply struct {
+	Contacts []wallet.Contact
+}
+
+type MessageArgs struct {
+	Address string
+	Message string
+	Wallet  string
+}
+
+type MessageReply struct {
+	Signature string
+}
+
+// BackupArgs copies Wallet to Dest, a path on the host of the node, or splits
+// it into Shares shares any Threshold of which recover it
+type BackupArgs struct {
+	Wallet    string
+	Dest      string
+	Shares    int
+	Threshold int
+}
+
+type BackupReply struct {
+	Path   string
+	Shares []wallet.BackupShare
+}
+
+type RecoverArgs struct {
+	Name   string
+	Shares []wallet.BackupShare
+}
+
+type RecoverReply struct {
+	Addresses int
+}
+
+// GetBalance sums the outputs of an address and of its change addresses
+func (n *Node) GetBalance(args BalanceArgs, reply *BalanceReply) error {
+	pubKeyHash, err := core.DecodeAddress(args.Address)
+	if err != nil {
+		return err
+	}
+	wallets, err := n.manager.Select(args.Wallet)
+	if err != nil {
+		return err
+	}
+
+	UTXOSet := core.UTXOSet{Blockchain: n.chain}
+	return n.chain.View(func() error {
+		balance, err := UTXOSet.Balance(pubKeyHash)
+		if err != nil {
+			return err
+		}
+		reply.Balance = balance
+
+		for _, change := range wallets.GetChangeAddresses(args.Address) {
+			changeHash, err := core.DecodeAddress(change)
+			if err != nil {
+				return err
+			}
+			amount, err := UTXOSet.Balance(changeHash)
+			if err != nil {
+				return err
+			}
+			reply.ChangeBalance += amount
+		}
+		return nil
+	})
+}
+
+// Send pays every recipient from one transaction, then mines it on this node
+// or puts it in the memory pool of the node
+func (n *Node) Send(args SendArgs, reply *TxReply) error {
+	if _, err := core.DecodeAddress(args.From); err != nil {
+		return err
+	}
+	for to, amount := range args.Amounts {
+		if _, err := core.DecodeAddress(to); err != nil {
+			return err
+		}
+		if amount <= 0 {
+			return fmt.Errorf("%w: amount for %s must be positive", core.ErrInvalidAmount, to)
+		}
+	}
+	selector, err := core.GetCoinSelector(args.Strategy)
+	if err != nil {
+		return err
+	}
+	inputs, err := core.ParseOutpoints(args.Inputs)
+	if err != nil {
+		return err
+	}
+
+	wallets, err := n.manager.Select(args.Wallet)
+	if err != nil {
+		return err
+	}
+	if _, ok := wallets.Wallets[args.From]; !ok {
+		return fmt.Errorf("%w: %s is not in wallet %s", core.ErrMissingKey, args.From, wallets.Name())
+	}
+	w := wallets.GetWallet(args.From)
+	opts, err := wallets.WithChange(args.From, n.nodeId, args.ReuseChange, wallet.TxOptions{TxOptions: core.TxOptions{Selector: selector, Inputs: inputs, Spent: n.node.SpentByPool()}})
+	if err != nil {
+		return err
+	}
+
+	UTXOSet := core.UTXOSet{Blockchain: n.chain}
+	tx, err := mineOrSend(n.node, args.From, args.Mine, func() (*core.Transaction, error) {
+		return wallet.CreateMultiTx(&w, args.Amounts, &UTXOSet, opts)
+	})
+	if err != nil {
+		return err
+	}
+	reply.TxID = hex.EncodeToString(tx.Id)
+	return nil
+}
+
+// CreateRawTx funds an unsigned transaction from the UTXO set of the node
+func (n *Node) CreateRawTx(args RawTxArgs, reply *TxReply) error {
+	if _, err := core.DecodeAddress(args.From); err != nil {
+		return err
+	}
+	if args.Change != "" {
+		if _, err := core.DecodeAddress(args.Change); err != nil {
+			return err
+		}
+	}
+	for to := range args.Amounts {
+		if _, err := core.DecodeAddress(to); err != nil {
+			return err
+		}
+	}
+	selector, err := core.GetCoinSelector(args.Strategy)
+	if err != nil {
+		return err
+	}
+	inputs, err := core.ParseOutpoints(args.Inputs)
+	if err != nil {
+		return err
+	}
+
+	UTXOSet := core.UTXOSet{Blockchain: n.chain}
+	return n.chain.View(func() error {
+		raw, err := core.CreateRawTx(args.From, args.Amounts, args.Change, &UTXOSet, core.TxOptions{Selector: selector, Inputs: inputs, Spent: n.node.SpentByPool()})
+		if err != nil {
+			return err
+		}
+		reply.Tx = raw.Encode()
+		return nil
+	})
+}
+
+// SendRawTx checks a signed raw transaction and mines or relays it
+func (n *Node) SendRawTx(args RawTxArgs, reply *TxReply) error {
+	raw, err := core.DecodeRawTx(args.Tx)
+	if err != nil {
+		return err
+	}
+	if !raw.IsSigned() {
+		return fmt.Errorf("%w: raw transaction is not signed", core.ErrInvalidTx)
+	}
+
+	tx, err := mineOrSend(n.node, "", args.Mine, func() (*core.Transaction, error) {
+		tx := raw.Tx
+		return &tx, checkRawTx(n.chain, &tx)
+	})
+	if err != nil {
+		return err
+	}
+	reply.TxID = hex.EncodeToString(tx.Id)
+	return nil
+}
+
+// ListUnspent lists the unspent outputs locked to an address
+func (n *Node) ListUnspent(args BalanceArgs, reply *UnspentReply) error {
+	pubKeyHash, err := core.DecodeAddress(args.Address)
+	if err != nil {
+		return err
+	}
+
+	UTXOSet := core.UTXOSet{Blockchain: n.chain}
+	return n.chain.View(func() error {
+		reply.Outputs, err = UTXOSet.FindSpendableCandidates(pubKeyHash)
+		return err
+	})
+}
+
+// ListTransactions pages through the labeled history of one address, or of
+// every address of the wallet when none is given
+func (n *Node) ListTransactions(args TransactionsArgs, reply *TransactionsReply) error {
+	var pubKeyHashes [][]byte
+
+	wallets, err := n.manager.Select(args.Wallet)
+	if err != nil {
+		return err
+	}
+	addresses := wallets.GetAllAddresses()
+	if args.Address != "" {
+		addresses = []string{args.Address}
+	}
+	for _, address := range addresses {
+		pubKeyHash, err := core.DecodeAddress(address)
+		if err != nil {
+			return err
+		}
+		pubKeyHashes = append(pubKeyHashes, pubKeyHash)
+	}
+
+	var history []core.TxHistoryEntry
+	err = n.chain.View(func() error {
+		history, err = core.TxHistory{Blockchain: n.chain}.FindHistory(pubKeyHashes...)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	reply.Entries = wallets.LabelHistory(core.PageHistory(history, args.Skip, args.Count))
+	return nil
+}
+
+// GetBlocks returns the whole chain, newest block first
+func (n *Node) GetBlocks(args Empty, reply *BlocksReply) error {
+	return n.chain.View(func() error {
+		iter := n.chain.Iterator()
+		for {
+			block, err := iter.Next()
+			if err != nil {
+				return err
+			}
+			reply.Blocks = append(reply.Blocks, *block)
+			if len(block.PreviousHash) == 0 {
+				return nil
+			}
+		}
+	})
+}
+
+// ReindexUTXO rebuilds the UTXO set and the history index from the blocks
+func (n *Node) ReindexUTXO(args Empty, reply *ReindexReply) error {
+	return n.chain.Update(func() error {
+		UTXOSet := core.UTXOSet{Blockchain: n.chain}
+		if err := UTXOSet.Reindex(); err != nil {
+			return err
+		}
+		count, err := UTXOSet.CountTransactions()
+		reply.Transactions = count
+		return err
+	})
+}
+
+// ListBanned returns the hosts the node refuses as peers
+func (n *Node) ListBanned(args Empty, reply *BannedReply) error {
+	reply.Bans = n.node.Bans.List()
+	return nil
+}
+
+// SetBan bans a host and drops its peers, or lifts its ban
+func (n *Node) SetBan(args SetBanArgs, reply *Empty) error {
+	if args.Remove {
+		ok, err := n.node.Bans.Unban(args.Host)
+		if err == nil && !ok {
+			err = fmt.Errorf("%s is not banned", args.Host)
+		}
+		return err
+	}
+	if args.Duration <= 0 {
+		args.Duration = p2p.DefaultBanDuration
+	}
+	return n.node.SetBan(args.Host, args.Duration, "banned by hand")
+}
+
+// ClearBanned lifts every ban
+func (n *Node) ClearBanned(args Empty, reply *Empty) error {
+	return n.node.Bans.Clear()
+}
+
+// CreateWallet makes a named wallet with a first address and loads it, or
+// adds an address to a loaded wallet
+func (n *Node) CreateWallet(args WalletArgs, reply *AddressReply) error {
+	if args.Name != "" {
+		_, address, err := n.manager.Create(args.Name, args.Bech32)
+		if err != nil {
+			return fmt.Errorf("%s: %w", args.Name, err)
+		}
+		reply.Address = address
+		return nil
+	}
+
+	wallets, err := n.manager.Select(args.Wallet)
+	if err != nil {
+		return err
+	}
+	return wallets.Update(n.nodeId, func() error {
+		if args.Bech32 {
+			reply.Address = wallets.AddBech32Wallet()
+		} else {
+			reply.Address = wallets.AddWallets()
+		}
+		return nil
+	})
+}
+
+func (n *Node) LoadWallet(args WalletArgs, reply *Empty) error {
+	if err := n.manager.Load(args.Name); err != nil {
+		return fmt.Errorf("%s: %w", args.Name, err)
+	}
+	return nil
+}
+
+func (n *Node) UnloadWallet(args WalletArgs, reply *Empty) error {
+	if err := n.manager.Unload(args.Name); err != nil {
+		return fmt.Errorf("%s: %w", args.Name, err)
+	}
+	return nil
+}
+
+// ListWallets lists the wallets that have a file and the loaded ones
+func (n *Node) ListWallets(args Empty, reply *WalletsReply) error {
+	reply.Names = wallet.GetWalletFileNames(n.nodeId)
+	reply.Loaded = n.manager.LoadedNames()
+	return nil
+}
+
+// ListAddresses lists the addresses of a wallet, sorted
+func (n *Node) ListAddresses(args WalletArgs, reply *AddressesReply) error {
+	wallets, err := n.manager.Select(args.Wallet)
+	if err != nil {
+		return err
+	}
+	for _, address := range wallets.GetAllAddresses() {
+		reply.Addresses = append(reply.Addresses, AddressInfo{address, wallets.Labels[address], wallets.ChangeOf[address]})
+	}
+	return nil
+}
+
+func (n *Node) SetLabel(args LabelArgs, reply *Empty) error {
+	wallets, err := n.manager.Select(args.Wallet)
+	if err != nil {
+		return err
+	}
+	return wallets.Update(n.nodeId, func() error {
+		return wallets.SetLabel(args.Address, args.Label)
+	})
+}
+
+func (n *Node) AddContact(args LabelArgs, reply *Empty) error {
+	wallets, err := n.manager.Select(args.Wallet)
+	if err != nil {
+		return err
+	}
+	return wallets.Update(n.nodeId, func() error {
+		return wallets.SetContact(args.Address, args.Label)
+	})
+}
+
+func (n *Node) RemoveContact(args LabelArgs, reply *Empty) error {
+	wallets, err := n.manager.Select(args.Wallet)
+	if err != nil {
+		return err
+	}
+	return wallets.Update(n.nodeId, func() error {
+		return wallets.RemoveContact(args.Address)
+	})
+}
+
+func (n *Node) ListContacts(args WalletArgs, reply *ContactsReply) error {
+	wallets, err := n.manager.Select(args.Wallet)
+	if err != nil {
+		return err
+	}
+	reply.Contacts = wallets.GetContacts()
+	return nil
+}
+
+// SignMessage signs a message with the key of an address of the wallet
+func (n *Node) SignMessage(args MessageArgs, reply *MessageReply) error {
+	if _, err := core.DecodeAddress(args.Address); err != nil {
+		return err
+	}
+	wallets, err := n.manager.Select(args.Wallet)
+	if err != nil {
+		return err
+	}
+	if _, ok := wallets.Wallets[args.Address]; !ok {
+		return fmt.Errorf("%w: %s is not in wallet %s", core.ErrMissingKey, args.Address, wallets.Name())
+	}
+	w := wallets.GetWallet(args.Address)
+	reply.Signature = wallet.SignMessage(&w, args.Message)
+	return nil
+}
+
+// SignRawTx signs the inputs of a raw transaction that the wallet has keys for
+func (n *Node) SignRawTx(args RawTxArgs, reply *TxReply) error {
+	raw, err := core.DecodeRawTx(args.Tx)
+	if err != nil {
+		return err
+	}
+	wallets, err := n.manager.Select(args.Wallet)
+	if err != nil {
+		return err
+	}
+	if err := wallets.SignRawTx(&raw); err != nil {
+		return err
+	}
+	reply.TxID = hex.EncodeToString(raw.Tx.Id)
+	reply.Tx = raw.Encode()
+	return nil
+}
+
+// BackupWallet copies the wallet file to a path on the host of the node
+func (n *Node) BackupWallet(args BackupArgs, reply *BackupReply) error {
+	name := walletName(args.Wallet)
+	path, err := wallet.BackupWallet(n.nodeId, name, args.Dest)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	reply.Path = path
+	return nil
+}
+
+// SplitBackup splits the wallet file into shares, the client stores them
+func (n *Node) SplitBackup(args BackupArgs, reply *BackupReply) error {
+	name := walletName(args.Wallet)
+	shares, err := wallet.SplitWallet(n.nodeId, name, args.Shares, args.Threshold)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	reply.Shares = shares
+	return nil
+}
+
+// RecoverBackup recombines shares into a new wallet and loads it
+func (n *Node) RecoverBackup(args RecoverArgs, reply *RecoverReply) error {
+	wallets, err := n.manager.Recover(args.Name, args.Shares)
+	if err != nil {
+		return fmt.Errorf("%s: %w", args.Name, err)
+	}
+	reply.Addresses = len(wallets.Wallets)
+	return nil
+}
+
+// walletName is the wallet a selector names, the default one when it is empty
+func walletName(selector string) string {
+	if selector == "" {
+		return wallet.DefaultWalletName
+	}
+	return selector
+}
+
+// RPCServer serves the Node service of one node
+type RPCServer struct {
+	listener net.Listener
+	server   *rpc.Server
+	cookie   string
+	file     string
+}
+
+// NewRPCServer listens for clients of the node nodeId and publishes the
+// address and cookie they need. Wallets are those of manager.
+func NewRPCServer(node *p2p.Node, nodeId string, manager *wallet.Manager) (*RPCServer, error) {
+	secret := make([]byte, rpcCookieLength)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	server := rpc.NewServer()
+	if err := server.RegisterName("Node", &Node{node.Chain, node, nodeId, manager}); err != nil {
+		return nil, err
+	}
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &RPCServer{ln, server, hex.EncodeToString(secret), rpcCookieFile(nodeId)}
+	content := fmt.Sprintf("%s\n%s\n", ln.Addr(), s.cookie)
+	if err := wallet.WriteFileAtomic(s.file, []byte(content), 0600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Serve answers clients until the server is closed
+func (s *RPCServer) Serve() error {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *RPCServer) serveConn(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(rpcAuthTimeout))
+	line, err := reader.ReadString('\n')
+	if err != nil || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(line)), []byte(s.cookie)) != 1 {
+		log.Printf("rejected RPC client %s", conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	s.server.ServeConn(struct {
+		io.Reader
+		io.Writer
+		io.Closer
+	}{reader, conn, conn})
+}
+
+// Close stops listening and removes the cookie file
+func (s *RPCServer) Close() error {
+	os.Remove(s.file)
+	return s.listener.Close()
+}
+
+// Client calls the Node service of a running node, as in
+// client.Call("Node.GetBalance", args, &reply)
+type Client struct {
+	*rpc.Client
+}
+
+// DialNode connects to the running node nodeId, errors wrap
+// ErrNodeNotRunning when there is none
+func DialNode(nodeId string) (*Client, error) {
+	content, err := ioutil.ReadFile(rpcCookieFile(nodeId))
+	if os.IsNotExist(err) {
+		return nil, ErrNodeNotRunning
+	}
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("%s is not an RPC cookie file", rpcCookieFile(nodeId))
+	}
+
+	conn, err := net.Dial("tcp", fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNodeNotRunning, err)
+	}
+	if _, err := fmt.Fprintf(conn, "%s\n", fields[1]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Client{rpc.NewClient(conn)}, nil
+}
@@ -0,0 +1,778 @@
+package api
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"minhlpc/build_blockchain/core"
+	"minhlpc/build_blockchain/p2p"
+	"minhlpc/build_blockchain/wallet"
+)
+
+type DataSend struct {
+	From        string   `json:"from"`
+	To          string   `json:"to"`
+	Amount      string   `json:"amount"`
+	Mine        bool     `json:"mine"`
+	Strategy    string   `json:"strategy"`
+	Inputs      []string `json:"inputs"`
+	ReuseChange bool     `json:"reuse_change"`
+	Wallet      string   `json:"wallet"`
+}
+
+type DataSendMany struct {
+	From        string         `json:"from"`
+	Amounts     map[string]int `json:"amounts"`
+	Mine        bool           `json:"mine"`
+	Strategy    string         `json:"strategy"`
+	Inputs      []string       `json:"inputs"`
+	ReuseChange bool           `json:"reuse_change"`
+	Wallet      string         `json:"wallet"`
+}
+
+type DataRawTx struct {
+	From     string         `json:"from"`
+	Amounts  map[string]int `json:"amounts"`
+	Change   string         `json:"change"`
+	Strategy string         `json:"strategy"`
+	Inputs   []string       `json:"inputs"`
+	Tx       string         `json:"tx"`
+	Mine     bool           `json:"mine"`
+	Wallet   string         `json:"wallet"`
+}
+
+type DataMessage struct {
+	Address   string `json:"address"`
+	Message   string `json:"message"`
+	Signature string `json:"signature"`
+	Wallet    string `json:"wallet"`
+}
+
+type DataLabel struct {
+	Address string `json:"address"`
+	Label   string `json:"label"`
+	Wallet  string `json:"wallet"`
+}
+
+// errorStatus maps an error of the core API to an HTTP status code, errors it
+// does not know get fallback
+func errorStatus(err error, fallback int) int {
+	switch {
+	case errors.Is(err, core.ErrInvalidAddress), errors.Is(err, core.ErrInvalidAmount),
+		errors.Is(err, core.ErrInvalidTx), errors.Is(err, core.ErrMissingKey):
+		return 400
+	case errors.Is(err, core.ErrBlockNotFound), errors.Is(err, core.ErrTxNotFound),
+		errors.Is(err, core.ErrChainNotFound), errors.Is(err, wallet.ErrWalletNotFound),
+		errors.Is(err, wallet.ErrWalletNotLoaded):
+		return 404
+	case errors.Is(err, core.ErrChainExists), errors.Is(err, wallet.ErrWalletExists):
+		return 409
+	case errors.Is(err, core.ErrInsufficientFunds):
+		return 422
+	}
+	return fallback
+}
+
+// respondError answers with the status code of err, 500 when it is not an
+// error of the core API
+func respondError(c *gin.Context, err error) {
+	c.JSON(errorStatus(err, 500), gin.H{
+		"message": err.Error(),
+	})
+}
+
+// selectWallets opens the wallet picked by the request, answering 404 when it
+// is not loaded
+func selectWallets(c *gin.Context, manager *wallet.Manager, name string) (*wallet.Wallets, bool) {
+	wallets, err := manager.Select(name)
+	if err != nil {
+		respondError(c, err)
+		return nil, false
+	}
+	return wallets, true
+}
+
+// getWallet finds the key of address in wallets, answering 404 when it is not
+// there
+func getWallet(c *gin.Context, wallets *wallet.Wallets, address string) (wallet.Wallet, bool) {
+	if _, ok := wallets.Wallets[address]; !ok {
+		c.JSON(404, gin.H{
+			"message": fmt.Sprintf("address %s is not in wallet %s", address, wallets.Name()),
+		})
+		return wallet.Wallet{}, false
+	}
+	return wallets.GetWallet(address), true
+}
+
+// mineOrSend runs create with the chain locked for writing and mines the
+// transaction it returns into a block on this node, with a coinbase paying
+// from if it is set. Without mineNow the transaction goes to the memory pool
+// of the node instead. Either is announced to the peers once the chain is
+// unlocked, since the node may mine the pool.
+func mineOrSend(node *p2p.Node, from string, mineNow bool, create func() (*core.Transaction, error)) (*core.Transaction, error) {
+	chain := node.Chain
+	var tx *core.Transaction
+	var block *core.Block
+	err := chain.Update(func() error {
+		var err error
+		tx, err = create()
+		if err != nil || !mineNow {
+			return err
+		}
+
+		txs := []*core.Transaction{tx}
+		if from != "" {
+			cbTx, err := core.CreateCoinbaseTx(from, "")
+			if err != nil {
+				return err
+			}
+			txs = []*core.Transaction{cbTx, tx}
+		}
+		block, err = chain.MineBlock(txs)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !mineNow {
+		return tx, node.SubmitTx(tx)
+	}
+	node.AnnounceBlock(block)
+	return tx, nil
+}
+
+// checkRawTx checks the signatures of a raw transaction and that it spends
+// unspent outputs that cover what it pays
+func checkRawTx(chain *core.BlockChain, tx *core.Transaction) error {
+	if err := chain.VerifyTransaction(tx); err != nil {
+		return err
+	}
+	UTXOSet := core.UTXOSet{Blockchain: chain}
+	_, err := UTXOSet.CheckInputs(tx, make(map[string]bool))
+	return err
+}
+
+// NewRouter returns the REST API of node, serving its chain and the wallets
+// of manager. The chain stays open for the life of the router and is shared
+// with the other services of the node.
+func NewRouter(node *p2p.Node, manager *wallet.Manager) *gin.Engine {
+	chain := node.Chain
+	r := gin.Default()
+	r.Use(cors.Default())
+	r.POST("/createwallet", func(c *gin.Context) {
+		nodeId := node.ID
+		if name := c.Query("name"); name != "" {
+			_, address, err := manager.Create(name, c.Query("type") == "bech32")
+			if err != nil {
+				c.JSON(errorStatus(err, 400), gin.H{
+					"message": err.Error(),
+				})
+				return
+			}
+			c.JSON(200, gin.H{
+				"wallet": name,
+				"data":   address,
+			})
+			return
+		}
+
+		wallets, ok := selectWallets(c, manager, c.Query("wallet"))
+		if !ok {
+			return
+		}
+		var address string
+		err := wallets.Update(nodeId, func() error {
+			if c.Query("type") == "bech32" {
+				address = wallets.AddBech32Wallet()
+			} else {
+				address = wallets.AddWallets()
+			}
+			return nil
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"data": address,
+		})
+	})
+	r.POST("/send", func(c *gin.Context) {
+		nodeId := node.ID
+		var data DataSend
+		if err := c.BindJSON(&data); err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		if _, err := core.DecodeAddress(data.From); err != nil {
+			c.JSON(400, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+
+		if _, err := core.DecodeAddress(data.To); err != nil {
+			c.JSON(400, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+
+		wallets, ok := selectWallets(c, manager, data.Wallet)
+		if !ok {
+			return
+		}
+		w, ok := getWallet(c, wallets, data.From)
+		if !ok {
+			return
+		}
+
+		UTXOSet := core.UTXOSet{Blockchain: chain}
+		amount, _ := strconv.ParseInt(data.Amount, 10, 64)
+		selector, err := core.GetCoinSelector(data.Strategy)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+		inputs, err := core.ParseOutpoints(strings.Join(data.Inputs, ","))
+		if err != nil {
+			c.JSON(400, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+		opts, err := wallets.WithChange(data.From, nodeId, data.ReuseChange, wallet.TxOptions{TxOptions: core.TxOptions{Selector: selector, Inputs: inputs, Spent: node.SpentByPool()}})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		from := data.From
+		if !data.Mine {
+			from = ""
+		}
+		tx, err := mineOrSend(node, from, true, func() (*core.Transaction, error) {
+			return wallet.CreateTx(&w, data.To, int(amount), &UTXOSet, opts)
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(200, gin.H{
+			"txid": hex.EncodeToString(tx.Id),
+		})
+	})
+	r.POST("/sendmany", func(c *gin.Context) {
+		nodeId := node.ID
+		var data DataSendMany
+		if err := c.BindJSON(&data); err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		if _, err := core.DecodeAddress(data.From); err != nil {
+			c.JSON(400, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+		if len(data.Amounts) == 0 {
+			c.JSON(400, gin.H{
+				"message": "no recipients",
+			})
+			return
+		}
+		total := 0
+		for to, amount := range data.Amounts {
+			if _, err := core.DecodeAddress(to); err != nil {
+				c.JSON(400, gin.H{
+					"message": err.Error(),
+				})
+				return
+			}
+			if amount <= 0 {
+				c.JSON(400, gin.H{
+					"message": fmt.Sprintf("amount for %s must be positive", to),
+				})
+				return
+			}
+			total += amount
+		}
+		selector, err := core.GetCoinSelector(data.Strategy)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+		inputs, err := core.ParseOutpoints(strings.Join(data.Inputs, ","))
+		if err != nil {
+			c.JSON(400, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+
+		wallets, ok := selectWallets(c, manager, data.Wallet)
+		if !ok {
+			return
+		}
+		w, ok := getWallet(c, wallets, data.From)
+		if !ok {
+			return
+		}
+
+		UTXOSet := core.UTXOSet{Blockchain: chain}
+		opts, err := wallets.WithChange(data.From, nodeId, data.ReuseChange, wallet.TxOptions{TxOptions: core.TxOptions{Selector: selector, Inputs: inputs, Spent: node.SpentByPool()}})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		from := data.From
+		if !data.Mine {
+			from = ""
+		}
+		tx, err := mineOrSend(node, from, true, func() (*core.Transaction, error) {
+			return wallet.CreateMultiTx(&w, data.Amounts, &UTXOSet, opts)
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(200, gin.H{
+			"txid":  hex.EncodeToString(tx.Id),
+			"total": total,
+		})
+	})
+	r.POST("/createrawtx", func(c *gin.Context) {
+		var data DataRawTx
+		if err := c.BindJSON(&data); err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		if _, err := core.DecodeAddress(data.From); err != nil {
+			c.JSON(400, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+		if data.Change != "" {
+			if _, err := core.DecodeAddress(data.Change); err != nil {
+				c.JSON(400, gin.H{
+					"message": err.Error(),
+				})
+				return
+			}
+		}
+		if len(data.Amounts) == 0 {
+			c.JSON(400, gin.H{
+				"message": "no recipients",
+			})
+			return
+		}
+		total := 0
+		for to, amount := range data.Amounts {
+			if !core.ValidateAddress(to) || amount <= 0 {
+				c.JSON(400, gin.H{
+					"message": fmt.Sprintf("payment to %s is not valid", to),
+				})
+				return
+			}
+			total += amount
+		}
+		selector, err := core.GetCoinSelector(data.Strategy)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+		inputs, err := core.ParseOutpoints(strings.Join(data.Inputs, ","))
+		if err != nil {
+			c.JSON(400, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+
+		UTXOSet := core.UTXOSet{Blockchain: chain}
+		var raw core.RawTx
+		err = chain.View(func() error {
+			var err error
+			raw, err = core.CreateRawTx(data.From, data.Amounts, data.Change, &UTXOSet, core.TxOptions{Selector: selector, Inputs: inputs, Spent: node.SpentByPool()})
+			return err
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(200, gin.H{
+			"tx":    raw.Encode(),
+			"total": total,
+		})
+	})
+	r.POST("/signrawtx", func(c *gin.Context) {
+		var data DataRawTx
+		if err := c.BindJSON(&data); err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		raw, err := core.DecodeRawTx(data.Tx)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+		wallets, ok := selectWallets(c, manager, data.Wallet)
+		if !ok {
+			return
+		}
+		if err := wallets.SignRawTx(&raw); err != nil {
+			c.JSON(errorStatus(err, 400), gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"txid":  hex.EncodeToString(raw.Tx.Id),
+			"spent": raw.InputAmount(),
+			"tx":    raw.Encode(),
+		})
+	})
+	r.POST("/sendrawtx", func(c *gin.Context) {
+		var data DataRawTx
+		if err := c.BindJSON(&data); err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		raw, err := core.DecodeRawTx(data.Tx)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+		if !raw.IsSigned() {
+			c.JSON(400, gin.H{
+				"message": "raw transaction is not signed",
+			})
+			return
+		}
+
+		tx, err := mineOrSend(node, "", data.Mine, func() (*core.Transaction, error) {
+			tx := raw.Tx
+			return &tx, checkRawTx(chain, &tx)
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"txid": hex.EncodeToString(tx.Id),
+		})
+	})
+	r.POST("/signmessage", func(c *gin.Context) {
+		var data DataMessage
+		if err := c.BindJSON(&data); err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		if _, err := core.DecodeAddress(data.Address); err != nil {
+			c.JSON(400, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+		wallets, ok := selectWallets(c, manager, data.Wallet)
+		if !ok {
+			return
+		}
+		w, ok := getWallet(c, wallets, data.Address)
+		if !ok {
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"address":   data.Address,
+			"signature": wallet.SignMessage(&w, data.Message),
+		})
+	})
+	r.POST("/verifymessage", func(c *gin.Context) {
+		var data DataMessage
+		if err := c.BindJSON(&data); err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		valid, err := wallet.VerifyMessage(data.Address, data.Signature, data.Message)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"address": data.Address,
+			"valid":   valid,
+		})
+	})
+	r.POST("/loadwallet", func(c *gin.Context) {
+		name := c.Query("name")
+		if err := manager.Load(name); err != nil {
+			c.JSON(errorStatus(err, 400), gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"wallet": name,
+		})
+	})
+	r.POST("/unloadwallet", func(c *gin.Context) {
+		name := c.Query("name")
+		if err := manager.Unload(name); err != nil {
+			c.JSON(errorStatus(err, 400), gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+		c.JSON(200, gin.H{
+			"wallet": name,
+		})
+	})
+	r.GET("/listwallets", func(c *gin.Context) {
+		nodeId := node.ID
+		c.JSON(200, gin.H{
+			"data":   wallet.GetWalletFileNames(nodeId),
+			"loaded": manager.LoadedNames(),
+		})
+	})
+	r.GET("/listaddresses", func(c *gin.Context) {
+		wallets, ok := selectWallets(c, manager, c.Query("wallet"))
+		if !ok {
+			return
+		}
+		addresses := wallets.GetAllAddresses()
+		c.JSON(200, gin.H{
+			"data":   addresses,
+			"change": wallets.ChangeOf,
+			"labels": wallets.Labels,
+		})
+	})
+	r.POST("/setlabel", func(c *gin.Context) {
+		nodeId := node.ID
+		var data DataLabel
+		if err := c.BindJSON(&data); err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		wallets, ok := selectWallets(c, manager, data.Wallet)
+		if !ok {
+			return
+		}
+		err := wallets.Update(nodeId, func() error {
+			return wallets.SetLabel(data.Address, data.Label)
+		})
+		if err != nil {
+			c.JSON(errorStatus(err, 400), gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"address": data.Address,
+			"label":   data.Label,
+		})
+	})
+	r.GET("/contacts", func(c *gin.Context) {
+		wallets, ok := selectWallets(c, manager, c.Query("wallet"))
+		if !ok {
+			return
+		}
+		c.JSON(200, gin.H{
+			"data": wallets.GetContacts(),
+		})
+	})
+	r.POST("/contacts", func(c *gin.Context) {
+		nodeId := node.ID
+		var data DataLabel
+		if err := c.BindJSON(&data); err != nil {
+			fmt.Println(err)
+			return
+		}
+		if data.Label == "" {
+			c.JSON(400, gin.H{
+				"message": "label is required",
+			})
+			return
+		}
+
+		wallets, ok := selectWallets(c, manager, data.Wallet)
+		if !ok {
+			return
+		}
+		err := wallets.Update(nodeId, func() error {
+			return wallets.SetContact(data.Address, data.Label)
+		})
+		if err != nil {
+			c.JSON(errorStatus(err, 400), gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"address": data.Address,
+			"label":   data.Label,
+		})
+	})
+	r.DELETE("/contacts", func(c *gin.Context) {
+		nodeId := node.ID
+		address := c.Query("address")
+		wallets, ok := selectWallets(c, manager, c.Query("wallet"))
+		if !ok {
+			return
+		}
+		err := wallets.Update(nodeId, func() error {
+			return wallets.RemoveContact(address)
+		})
+		if err != nil {
+			c.JSON(errorStatus(err, 404), gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"address": address,
+		})
+	})
+	r.GET("/getbalance", func(c *gin.Context) {
+		address := c.Query("address")
+		pubKeyHash, err := core.DecodeAddress(address)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		wallets, ok := selectWallets(c, manager, c.Query("wallet"))
+		if !ok {
+			return
+		}
+		utxoSet := core.UTXOSet{Blockchain: chain}
+
+		var balance, changeBalance int
+		err = chain.View(func() error {
+			var err error
+			balance, err = utxoSet.Balance(pubKeyHash)
+			if err != nil {
+				return err
+			}
+			for _, change := range wallets.GetChangeAddresses(address) {
+				changeHash, err := core.DecodeAddress(change)
+				if err != nil {
+					return err
+				}
+				amount, err := utxoSet.Balance(changeHash)
+				if err != nil {
+					return err
+				}
+				changeBalance += amount
+			}
+			return nil
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"address":        address,
+			"balance":        balance + changeBalance,
+			"own_balance":    balance,
+			"change_balance": changeBalance,
+		})
+	})
+	r.GET("/transactions", func(c *gin.Context) {
+		address := c.Query("address")
+		pubKeyHash, err := core.DecodeAddress(address)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+		if err != nil || limit < 1 {
+			limit = 10
+		}
+
+		wallets, ok := selectWallets(c, manager, c.Query("wallet"))
+		if !ok {
+			return
+		}
+
+		var history []core.TxHistoryEntry
+		err = chain.View(func() error {
+			var err error
+			history, err = core.TxHistory{Blockchain: chain}.FindHistory(pubKeyHash)
+			return err
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"address": address,
+			"label":   wallets.GetLabel(address),
+			"page":    page,
+			"limit":   limit,
+			"total":   len(history),
+			"data":    wallets.LabelHistory(core.PageHistory(history, (page-1)*limit, limit)),
+		})
+	})
+	r.GET("/print", func(c *gin.Context) {
+		var data []core.Block
+		err := chain.View(func() error {
+			iter := chain.Iterator()
+			for {
+				block, err := iter.Next()
+				if err != nil {
+					return err
+				}
+				data = append(data, *block)
+				if len(block.PreviousHash) == 0 {
+					return nil
+				}
+			}
+		})
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(200, gin.H{
+			"data": data,
+		})
+	})
+	return r
+}
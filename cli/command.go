@@ -0,0 +1,1095 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"minhlpc/build_blockchain/api"
+	"minhlpc/build_blockchain/core"
+	"minhlpc/build_blockchain/daemon"
+	"minhlpc/build_blockchain/p2p"
+	"minhlpc/build_blockchain/wallet"
+)
+
+type Command struct{}
+
+func (cli *Command) printMenu() {
+	nodeId := os.Getenv("NODE_ID")
+	fmt.Println("NODE_ID: ", nodeId)
+	fmt.Println("Network: ", core.ActiveNet().Name, "(select with -network [mainnet|testnet|regtest] before the command)")
+	fmt.Println("Before the command -datadir [directory] places the node files, -nodeid [id] names them instead of NODE_ID")
+	fmt.Println("Commands:")
+	fmt.Println("Create blockchain: initChain -address [address]")
+	fmt.Println("View all blocks: print")
+	fmt.Println("Send coins from one to another address, then -mine flag is set, mine off of this node: send -from [fromAddress] -to [toAddress] -amount [amount] -mine")
+	fmt.Println("  coin selection: -strategy [auto|largest|bnb|random], always spend outpoints: -inputs [txid:index,...]")
+	fmt.Println("  change goes to a fresh change address, -reusechange sends it back to fromAddress")
+	fmt.Println("Send coins to several addresses in one transaction: sendmany -from [fromAddress] -amounts '{\"address\":amount,...}' -mine")
+	fmt.Println("Create an unsigned transaction on an online node: createrawtx -from [fromAddress] -to [toAddress] -amount [amount] -change [changeAddress]")
+	fmt.Println("Sign a raw transaction with this node's wallet, no blockchain or running node needed: signrawtx -tx [hex]")
+	fmt.Println("Broadcast a signed raw transaction, -mine mines it on this node: sendrawtx -tx [hex] -mine")
+	fmt.Println("Prove ownership of an address: signmessage -address [address] -message [message]")
+	fmt.Println("Check a signed message: verifymessage -address [address] -signature [signature] -message [message]")
+	fmt.Println("List unspent outputs of an address: listunspent -address [address]")
+	fmt.Println("Get balance of an address: getBalance -address [address]")
+	fmt.Println("List all addresses: listAddresses")
+	fmt.Println("List wallet transactions, newest first: listtransactions -address [address] -skip [n] -count [n]")
+	fmt.Println("Label one of your addresses, an empty label removes it: setlabel -address [address] -label [label]")
+	fmt.Println("Manage the address book: addcontact -address [address] -label [label], removecontact -address [address], listcontacts")
+	fmt.Println("Create wallets, -bech32 gives a bech32 address: createwallet -bech32")
+	fmt.Println("Create a new named wallet: createwallet -name [name]")
+	fmt.Println("Load, unload and list named wallets: loadwallet -name [name], unloadwallet -name [name], listwallets")
+	fmt.Println("Copy a wallet file, into a directory or to a file: backupwallet -dest [path]")
+	fmt.Println("Split a wallet backup into shares, any threshold of them recover it: splitbackup -shares [n] -threshold [m] -dest [directory]")
+	fmt.Println("Recover a wallet from backup shares: recoverbackup -name [name] -shares [share or file,...]")
+	fmt.Println("Commands that use a wallet take -wallet [name], the default wallet is used without it")
+	fmt.Println("Rebuild UTXO set: reindexutxo")
+	fmt.Println("Manage banned peers: listbanned, setban -host [host] -duration [24h], setban -host [host] -remove, clearbanned")
+	fmt.Println("Start a node with ID specified in NODE_ID env, -miner enables mining, -api serves the REST API: startnode -miner ADDRESS -api [address]")
+	fmt.Println("  addresses: -listen [host:port] accepts peers there instead of localhost:<NODE_ID>, -externaladdr [host:port] is given to peers to reach the node")
+	fmt.Println("  peers: -connect [host:port,...] connects only to these nodes, -addnode [host:port,...] stays connected to them next to the peers of the address book")
+	fmt.Println("  encryption: -encrypt dials peers over TLS and falls back to plaintext, -requireencryption refuses plaintext peers")
+	fmt.Println("Commands that read or change the chain talk to the running node, start it first")
+}
+
+func (cli *Command) validateArgs(args []string) {
+	if len(args) < 1 {
+		cli.printMenu()
+		runtime.Goexit()
+	}
+
+}
+
+func (cli *Command) print(nodeId string) error {
+	var reply api.BlocksReply
+	if err := cli.call(nodeId, "GetBlocks", api.Empty{}, &reply); err != nil {
+		return err
+	}
+	for i := range reply.Blocks {
+		block := &reply.Blocks[i]
+		fmt.Printf("-----------------------------------\n")
+		fmt.Printf("Hash: %x\n", block.Hash)
+		pow := core.StartProofOfWork(block)
+		fmt.Printf("Confirmed: %s\n", strconv.FormatBool(pow.Validate()))
+
+		for _, tx := range block.Transactions {
+			fmt.Println(tx)
+		}
+	}
+	return nil
+}
+
+// createBlockChain opens the database itself, a node only starts on an
+// existing chain so there is none to call yet
+func (cli *Command) createBlockChain(address, nodeId string) error {
+	chain, err := core.InitMyChain(address, nodeId)
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+	fmt.Println("Genesis created")
+
+	UTXOSet := core.UTXOSet{Blockchain: chain}
+	if err := UTXOSet.Reindex(); err != nil {
+		return err
+	}
+
+	fmt.Println("Finished!")
+	return nil
+}
+
+func (cli *Command) getBalance(address, nodeId, walletName string) error {
+	var reply api.BalanceReply
+	if err := cli.call(nodeId, "GetBalance", api.BalanceArgs{Address: address, Wallet: walletName}, &reply); err != nil {
+		return err
+	}
+
+	if reply.ChangeBalance > 0 {
+		fmt.Printf("Balance of %s: %d (%d own, %d in change addresses)\n", address, reply.Balance+reply.ChangeBalance, reply.Balance, reply.ChangeBalance)
+		return nil
+	}
+	fmt.Printf("Balance of %s: %d\n", address, reply.Balance)
+	return nil
+}
+
+func (cli *Command) send(from, to string, amount int, nodeId string, args api.SendArgs) error {
+	args.From = from
+	args.Amounts = map[string]int{to: amount}
+	return cli.sendMany(nodeId, args)
+}
+
+func (cli *Command) sendMany(nodeId string, args api.SendArgs) error {
+	var reply api.TxReply
+	if err := cli.call(nodeId, "Send", args, &reply); err != nil {
+		return err
+	}
+	if !args.Mine {
+		fmt.Println("send tx")
+	}
+
+	for to, amount := range args.Amounts {
+		fmt.Printf("%s sent %d to %s\n", args.From, amount, to)
+	}
+	return nil
+}
+
+func (cli *Command) createRawTx(nodeId string, args api.RawTxArgs) error {
+	var reply api.TxReply
+	if err := cli.call(nodeId, "CreateRawTx", args, &reply); err != nil {
+		return err
+	}
+	fmt.Println(reply.Tx)
+	return nil
+}
+
+// signRawTx signs with the wallet of the running node. Without a node it
+// signs with the wallet file itself, so a machine that only holds the wallet
+// can sign offline.
+func (cli *Command) signRawTx(data, nodeId, walletName string) error {
+	var reply api.TxReply
+	err := cli.call(nodeId, "SignRawTx", api.RawTxArgs{Tx: data, Wallet: walletName}, &reply)
+	if errors.Is(err, api.ErrNodeNotRunning) {
+		reply.Tx, err = signRawTxOffline(data, nodeId, walletName)
+	}
+	if err != nil {
+		return err
+	}
+	raw, err := core.DecodeRawTx(reply.Tx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(raw.Tx)
+	fmt.Printf("Spending %d\n", raw.InputAmount())
+	fmt.Println(reply.Tx)
+	return nil
+}
+
+func signRawTxOffline(data, nodeId, walletName string) (string, error) {
+	raw, err := core.DecodeRawTx(data)
+	if err != nil {
+		return "", err
+	}
+
+	walletName = walletDisplayName(walletName)
+	wallets, err := wallet.OpenWallets(nodeId, walletName)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("%s: %w", walletName, wallet.ErrWalletNotFound)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := wallets.SignRawTx(&raw); err != nil {
+		return "", err
+	}
+	return raw.Encode(), nil
+}
+
+func (cli *Command) sendRawTx(data, nodeId string, mineNow bool) error {
+	var reply api.TxReply
+	if err := cli.call(nodeId, "SendRawTx", api.RawTxArgs{Tx: data, Mine: mineNow}, &reply); err != nil {
+		return err
+	}
+	if !mineNow {
+		fmt.Println("send tx")
+	}
+
+	fmt.Printf("Transaction %s sent\n", reply.TxID)
+	return nil
+}
+
+func (cli *Command) signMessage(address, message, nodeId, walletName string) error {
+	var reply api.MessageReply
+	if err := cli.call(nodeId, "SignMessage", api.MessageArgs{Address: address, Message: message, Wallet: walletName}, &reply); err != nil {
+		return err
+	}
+
+	fmt.Println(reply.Signature)
+	return nil
+}
+
+// verifyMessage needs neither keys nor the chain, so it runs without a node
+func (cli *Command) verifyMessage(address, signature, message string) error {
+	valid, err := wallet.VerifyMessage(address, signature, message)
+	if err != nil {
+		return err
+	}
+
+	if valid {
+		fmt.Println("Signature is valid")
+	} else {
+		fmt.Println("Signature is NOT valid")
+	}
+	return nil
+}
+
+func (cli *Command) listUnspent(address, nodeId string) error {
+	var reply api.UnspentReply
+	if err := cli.call(nodeId, "ListUnspent", api.BalanceArgs{Address: address}, &reply); err != nil {
+		return err
+	}
+	for _, out := range reply.Outputs {
+		fmt.Printf("%s %d\n", out.Outpoint, out.Output.Amount)
+	}
+	return nil
+}
+
+func (cli *Command) listAddresses(nodeId, walletName string) error {
+	var reply api.AddressesReply
+	if err := cli.call(nodeId, "ListAddresses", api.WalletArgs{Wallet: walletName}, &reply); err != nil {
+		return err
+	}
+
+	for _, info := range reply.Addresses {
+		line := withLabel(info.Address, info.Label)
+		if info.ChangeOf != "" {
+			line += fmt.Sprintf(" (change of %s)", info.ChangeOf)
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+func (cli *Command) setLabel(address, label, nodeId, walletName string) error {
+	args := api.LabelArgs{Address: address, Label: label, Wallet: walletName}
+	if err := cli.call(nodeId, "SetLabel", args, &api.Empty{}); err != nil {
+		return err
+	}
+
+	if label == "" {
+		fmt.Printf("Removed the label of %s\n", address)
+	} else {
+		fmt.Printf("Labeled %s as %q\n", address, label)
+	}
+	return nil
+}
+
+func (cli *Command) addContact(address, label, nodeId, walletName string) error {
+	args := api.LabelArgs{Address: address, Label: label, Wallet: walletName}
+	if err := cli.call(nodeId, "AddContact", args, &api.Empty{}); err != nil {
+		return err
+	}
+	fmt.Printf("Saved contact %q: %s\n", label, address)
+	return nil
+}
+
+func (cli *Command) removeContact(address, nodeId, walletName string) error {
+	args := api.LabelArgs{Address: address, Wallet: walletName}
+	if err := cli.call(nodeId, "RemoveContact", args, &api.Empty{}); err != nil {
+		return err
+	}
+	fmt.Printf("Removed contact %s\n", address)
+	return nil
+}
+
+func (cli *Command) listContacts(nodeId, walletName string) error {
+	var reply api.ContactsReply
+	if err := cli.call(nodeId, "ListContacts", api.WalletArgs{Wallet: walletName}, &reply); err != nil {
+		return err
+	}
+	for _, contact := range reply.Contacts {
+		fmt.Printf("%s %q\n", contact.Address, contact.Label)
+	}
+	return nil
+}
+
+func (cli *Command) listTransactions(address, nodeId, walletName string, skip, count int) error {
+	var reply api.TransactionsReply
+	args := api.TransactionsArgs{Address: address, Wallet: walletName, Skip: skip, Count: count}
+	if err := cli.call(nodeId, "ListTransactions", args, &reply); err != nil {
+		return err
+	}
+	for _, entry := range reply.Entries {
+		fmt.Printf("-----------------------------------\n")
+		fmt.Printf("TXID:          %s\n", entry.TxID)
+		fmt.Printf("Address:       %s\n", withLabel(entry.Address, entry.Label))
+		fmt.Printf("Amount:        %+d\n", entry.Amount)
+		if entry.Coinbase {
+			fmt.Printf("Counterparty:  coinbase\n")
+		}
+		for _, counterparty := range entry.Counterparties {
+			fmt.Printf("Counterparty:  %s\n", withLabel(counterparty, entry.CounterpartyLabels[counterparty]))
+		}
+		fmt.Printf("Height:        %d\n", entry.Height)
+		fmt.Printf("Confirmations: %d\n", entry.Confirmations)
+		fmt.Printf("Time:          %s\n", time.Unix(entry.Timestamp, 0).Format(time.RFC3339))
+	}
+	return nil
+}
+
+func withLabel(address, label string) string {
+	if label == "" {
+		return address
+	}
+	return fmt.Sprintf("%s %q", address, label)
+}
+
+func (cli *Command) createWallet(nodeId, walletName, name string, bech32 bool) error {
+	var reply api.AddressReply
+	if err := cli.call(nodeId, "CreateWallet", api.WalletArgs{Name: name, Wallet: walletName, Bech32: bech32}, &reply); err != nil {
+		return err
+	}
+
+	if name != "" {
+		fmt.Printf("Created and loaded wallet %s\n", name)
+	}
+	fmt.Printf("Your address is: %s\n", reply.Address)
+	return nil
+}
+
+func (cli *Command) loadWallet(nodeId, name string) error {
+	if err := cli.call(nodeId, "LoadWallet", api.WalletArgs{Name: name}, &api.Empty{}); err != nil {
+		return err
+	}
+	fmt.Printf("Wallet %s is loaded\n", name)
+	return nil
+}
+
+func (cli *Command) unloadWallet(nodeId, name string) error {
+	if err := cli.call(nodeId, "UnloadWallet", api.WalletArgs{Name: name}, &api.Empty{}); err != nil {
+		return err
+	}
+	fmt.Printf("Wallet %s is unloaded\n", name)
+	return nil
+}
+
+func (cli *Command) listWallets(nodeId string) error {
+	var reply api.WalletsReply
+	if err := cli.call(nodeId, "ListWallets", api.Empty{}, &reply); err != nil {
+		return err
+	}
+
+	loaded := make(map[string]bool)
+	for _, name := range reply.Loaded {
+		loaded[name] = true
+	}
+	for _, name := range reply.Names {
+		if loaded[name] {
+			fmt.Printf("%s (loaded)\n", name)
+		} else {
+			fmt.Println(name)
+		}
+	}
+	return nil
+}
+
+// backupWallet has the node write the copy, dest is made absolute first since
+// the node may run in another directory
+func (cli *Command) backupWallet(nodeId, walletName, dest string) error {
+	dest, err := filepath.Abs(dest)
+	if err != nil {
+		return err
+	}
+	var reply api.BackupReply
+	if err := cli.call(nodeId, "BackupWallet", api.BackupArgs{Wallet: walletName, Dest: dest}, &reply); err != nil {
+		return err
+	}
+	fmt.Printf("Wallet %s is backed up to %s\n", walletDisplayName(walletName), reply.Path)
+	return nil
+}
+
+func (cli *Command) splitBackup(nodeId, walletName string, n, threshold int, dest string) error {
+	var reply api.BackupReply
+	if err := cli.call(nodeId, "SplitBackup", api.BackupArgs{Wallet: walletName, Shares: n, Threshold: threshold}, &reply); err != nil {
+		return err
+	}
+
+	walletName = walletDisplayName(walletName)
+	fmt.Printf("Wallet %s is split into %d shares, any %d of them recover it\n", walletName, n, threshold)
+	for _, share := range reply.Shares {
+		if dest == "" {
+			fmt.Printf("Share %d: %s\n", share.X(), share)
+			continue
+		}
+		file := filepath.Join(dest, fmt.Sprintf("%s_share_%d_of_%d.txt", walletName, share.X(), n))
+		if err := wallet.WriteFileAtomic(file, []byte(share.String()+"\n"), 0600); err != nil {
+			return err
+		}
+		fmt.Printf("Share %d: %s\n", share.X(), file)
+	}
+	return nil
+}
+
+// recoverBackup takes shares as text or as files holding one share each
+func (cli *Command) recoverBackup(nodeId, name, list string) error {
+	var shares []wallet.BackupShare
+	for _, item := range strings.Split(list, ",") {
+		text := item
+		if content, err := ioutil.ReadFile(item); err == nil {
+			text = string(content)
+		}
+		share, err := wallet.ParseBackupShare(text)
+		if err != nil {
+			return fmt.Errorf("%s: %w", item, err)
+		}
+		shares = append(shares, share)
+	}
+
+	var reply api.RecoverReply
+	if err := cli.call(nodeId, "RecoverBackup", api.RecoverArgs{Name: name, Shares: shares}, &reply); err != nil {
+		return err
+	}
+	fmt.Printf("Recovered wallet %s with %d addresses\n", name, reply.Addresses)
+	return nil
+}
+
+// walletDisplayName is the name of the wallet a -wallet flag picks
+func walletDisplayName(walletName string) string {
+	if walletName == "" {
+		return wallet.DefaultWalletName
+	}
+	return walletName
+}
+
+// call runs a method of the Node service on the running node nodeId
+func (cli *Command) call(nodeId, method string, args, reply interface{}) error {
+	client, err := api.DialNode(nodeId)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return client.Call("Node."+method, args, reply)
+}
+
+// fail prints err and exits with a failure status
+func (cli *Command) fail(err error) {
+	fmt.Println("Error:", err)
+	os.Exit(1)
+}
+
+// Run parses the command line and executes the command it names
+func (cli *Command) Run() {
+	globalCmd := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	network := globalCmd.String("network", core.MainNetParams.Name, "Network to run on: mainnet, testnet or regtest")
+	dataDir := globalCmd.String("datadir", ".", "Directory holding the chain, wallets and address book")
+	nodeIdFlag := globalCmd.String("nodeid", os.Getenv("NODE_ID"), "Name of the node files, NODE_ID env by default")
+	err := globalCmd.Parse(os.Args[1:])
+	if err != nil {
+		cli.fail(err)
+	}
+	if err := p2p.SelectNetwork(*network); err != nil {
+		cli.fail(err)
+	}
+	core.SetDataDir(*dataDir)
+	args := globalCmd.Args()
+	cli.validateArgs(args)
+
+	nodeId := *nodeIdFlag
+	if nodeId == "" {
+		fmt.Printf("NODE_ID env is not set!")
+		runtime.Goexit()
+	}
+
+	getBalanceCmd := flag.NewFlagSet("getBalance", flag.ExitOnError)
+	initChainCmd := flag.NewFlagSet("initChain", flag.ExitOnError)
+	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
+	printCmd := flag.NewFlagSet("print", flag.ExitOnError)
+	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
+	listAddressesCmd := flag.NewFlagSet("listAddresses", flag.ExitOnError)
+	loadWalletCmd := flag.NewFlagSet("loadwallet", flag.ExitOnError)
+	unloadWalletCmd := flag.NewFlagSet("unloadwallet", flag.ExitOnError)
+	listWalletsCmd := flag.NewFlagSet("listwallets", flag.ExitOnError)
+	backupWalletCmd := flag.NewFlagSet("backupwallet", flag.ExitOnError)
+	splitBackupCmd := flag.NewFlagSet("splitbackup", flag.ExitOnError)
+	recoverBackupCmd := flag.NewFlagSet("recoverbackup", flag.ExitOnError)
+	listTransactionsCmd := flag.NewFlagSet("listtransactions", flag.ExitOnError)
+	listUnspentCmd := flag.NewFlagSet("listunspent", flag.ExitOnError)
+	sendManyCmd := flag.NewFlagSet("sendmany", flag.ExitOnError)
+	createRawTxCmd := flag.NewFlagSet("createrawtx", flag.ExitOnError)
+	signMessageCmd := flag.NewFlagSet("signmessage", flag.ExitOnError)
+	verifyMessageCmd := flag.NewFlagSet("verifymessage", flag.ExitOnError)
+	signRawTxCmd := flag.NewFlagSet("signrawtx", flag.ExitOnError)
+	sendRawTxCmd := flag.NewFlagSet("sendrawtx", flag.ExitOnError)
+	setLabelCmd := flag.NewFlagSet("setlabel", flag.ExitOnError)
+	addContactCmd := flag.NewFlagSet("addcontact", flag.ExitOnError)
+	removeContactCmd := flag.NewFlagSet("removecontact", flag.ExitOnError)
+	listContactsCmd := flag.NewFlagSet("listcontacts", flag.ExitOnError)
+	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
+	walletUsage := "Wallet to use, defaults to the default wallet"
+	getBalanceWallet := getBalanceCmd.String("wallet", "", walletUsage)
+	sendWallet := sendCmd.String("wallet", "", walletUsage)
+	sendManyWallet := sendManyCmd.String("wallet", "", walletUsage)
+	listAddressesWallet := listAddressesCmd.String("wallet", "", walletUsage)
+	listTransactionsWallet := listTransactionsCmd.String("wallet", "", walletUsage)
+	signRawTxWallet := signRawTxCmd.String("wallet", "", walletUsage)
+	signMessageWallet := signMessageCmd.String("wallet", "", walletUsage)
+	setLabelWallet := setLabelCmd.String("wallet", "", walletUsage)
+	addContactWallet := addContactCmd.String("wallet", "", walletUsage)
+	removeContactWallet := removeContactCmd.String("wallet", "", walletUsage)
+	listContactsWallet := listContactsCmd.String("wallet", "", walletUsage)
+	createWalletWallet := createWalletCmd.String("wallet", "", "Wallet that gets the new address, defaults to the default wallet")
+	createWalletName := createWalletCmd.String("name", "", "Create a new named wallet instead of adding an address")
+	loadWalletName := loadWalletCmd.String("name", "", "Wallet to load")
+	unloadWalletName := unloadWalletCmd.String("name", "", "Wallet to unload")
+	backupWalletWallet := backupWalletCmd.String("wallet", "", "Wallet to back up, defaults to the default wallet")
+	backupWalletDest := backupWalletCmd.String("dest", "", "File or existing directory to write the backup to")
+	splitBackupWallet := splitBackupCmd.String("wallet", "", "Wallet to split, defaults to the default wallet")
+	splitBackupShares := splitBackupCmd.Int("shares", 0, "Number of shares to make")
+	splitBackupThreshold := splitBackupCmd.Int("threshold", 0, "Number of shares that recover the wallet")
+	splitBackupDest := splitBackupCmd.String("dest", "", "Directory to write one file per share to, shares are printed without it")
+	recoverBackupName := recoverBackupCmd.String("name", "", "Name of the recovered wallet, it must not exist")
+	recoverBackupShares := recoverBackupCmd.String("shares", "", "Comma separated shares or files holding one share each")
+	createWalletBech32 := createWalletCmd.Bool("bech32", false, "Use a bech32 address, which detects typos, instead of Base58")
+	createBlockchainAddress := initChainCmd.String("address", "", "The address to send genesis block reward to")
+	fromAddress := sendCmd.String("from", "", "Source wallet address")
+	toAddress := sendCmd.String("to", "", "Destination wallet address")
+	amount := sendCmd.Int("amount", 0, "Amount to send")
+	sendStrategy := sendCmd.String("strategy", "auto", "Coin selection strategy: auto, largest, bnb or random")
+	sendInputs := sendCmd.String("inputs", "", "Comma separated txid:index outpoints that must be spent")
+	sendReuseChange := sendCmd.Bool("reusechange", false, "Send change back to the source address instead of a fresh change address")
+	sendManyFrom := sendManyCmd.String("from", "", "Source wallet address")
+	sendManyAmounts := sendManyCmd.String("amounts", "", "JSON object mapping destination addresses to amounts")
+	sendManyMine := sendManyCmd.Bool("mine", false, "Mine immediately on the same node")
+	sendManyStrategy := sendManyCmd.String("strategy", "auto", "Coin selection strategy: auto, largest, bnb or random")
+	sendManyInputs := sendManyCmd.String("inputs", "", "Comma separated txid:index outpoints that must be spent")
+	sendManyReuseChange := sendManyCmd.Bool("reusechange", false, "Send change back to the source address instead of a fresh change address")
+	createRawTxFrom := createRawTxCmd.String("from", "", "Source wallet address")
+	createRawTxTo := createRawTxCmd.String("to", "", "Destination wallet address")
+	createRawTxAmount := createRawTxCmd.Int("amount", 0, "Amount to send")
+	createRawTxAmounts := createRawTxCmd.String("amounts", "", "JSON object mapping destination addresses to amounts, instead of -to and -amount")
+	createRawTxChange := createRawTxCmd.String("change", "", "Address that receives the change, defaults to the source address")
+	createRawTxStrategy := createRawTxCmd.String("strategy", "auto", "Coin selection strategy: auto, largest, bnb or random")
+	createRawTxInputs := createRawTxCmd.String("inputs", "", "Comma separated txid:index outpoints that must be spent")
+	signRawTxData := signRawTxCmd.String("tx", "", "Hex encoded raw transaction")
+	sendRawTxData := sendRawTxCmd.String("tx", "", "Hex encoded signed raw transaction")
+	sendRawTxMine := sendRawTxCmd.Bool("mine", false, "Mine immediately on the same node")
+	signMessageAddress := signMessageCmd.String("address", "", "The address whose key signs the message")
+	signMessageMessage := signMessageCmd.String("message", "", "The message to sign")
+	verifyMessageAddress := verifyMessageCmd.String("address", "", "The address that signed the message")
+	verifyMessageSignature := verifyMessageCmd.String("signature", "", "The signature returned by signmessage")
+	verifyMessageMessage := verifyMessageCmd.String("message", "", "The message that was signed")
+	listUnspentAddress := listUnspentCmd.String("address", "", "The address to list unspent outputs for")
+	setLabelAddress := setLabelCmd.String("address", "", "One of your addresses")
+	setLabelLabel := setLabelCmd.String("label", "", "The label, empty removes it")
+	addContactAddress := addContactCmd.String("address", "", "The address of the contact")
+	addContactLabel := addContactCmd.String("label", "", "The name of the contact")
+	removeContactAddress := removeContactCmd.String("address", "", "The address of the contact to remove")
+	listTransactionsAddress := listTransactionsCmd.String("address", "", "Only list transactions of this address, defaults to every wallet address")
+	listTransactionsSkip := listTransactionsCmd.Int("skip", 0, "Number of newest transactions to skip")
+	listTransactionsCount := listTransactionsCmd.Int("count", 10, "Number of transactions to list")
+	reindexUTXOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
+	listBannedCmd := flag.NewFlagSet("listbanned", flag.ExitOnError)
+	setBanCmd := flag.NewFlagSet("setban", flag.ExitOnError)
+	clearBannedCmd := flag.NewFlagSet("clearbanned", flag.ExitOnError)
+	setBanHost := setBanCmd.String("host", "", "Host or host:port to ban")
+	setBanDuration := setBanCmd.Duration("duration", p2p.DefaultBanDuration, "How long the ban lasts")
+	setBanRemove := setBanCmd.Bool("remove", false, "Lift the ban of the host instead")
+	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
+	sendMine := sendCmd.Bool("mine", false, "Mine immediately on the same node")
+	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and send reward to ADDRESS")
+	startNodeAPI := startNodeCmd.String("api", "", "Also serve the REST API on this address, for example :8080")
+	startNodeListen := startNodeCmd.String("listen", "", "Accept peers on this address, localhost:<NODE_ID> by default")
+	startNodeExternal := startNodeCmd.String("externaladdr", "", "Address peers reach this node on, the listen address by default")
+	startNodeConnect := startNodeCmd.String("connect", "", "Connect only to these nodes: host:port,...")
+	startNodeAddNode := startNodeCmd.String("addnode", "", "Stay connected to these nodes next to the ones of the address book: host:port,...")
+	startNodeEncrypt := startNodeCmd.Bool("encrypt", false, "Connect to peers over TLS, in plaintext to peers without it")
+	startNodeRequireEncryption := startNodeCmd.Bool("requireencryption", false, "Only talk to peers over TLS")
+
+	switch args[0] {
+	case "getBalance":
+		err := getBalanceCmd.Parse(args[1:])
+		if err != nil {
+			cli.fail(err)
+		}
+	case "initChain":
+		err := initChainCmd.Parse(args[1:])
+		if err != nil {
+			cli.fail(err)
+		}
+	case "print":
+		err := printCmd.Parse(args[1:])
+		if err != nil {
+			cli.fail(err)
+		}
+	case "send":
+		err := sendCmd.Parse(args[1:])
+		if err != nil {
+			cli.fail(err)
+		}
+	case "createwallet":
+		err := createWalletCmd.Parse(args[1:])
+		if err != nil {
+			cli.fail(err)
+		}
+	case "loadwallet":
+		err := loadWalletCmd.Parse(args[1:])
+		if err != nil {
+			cli.fail(err)
+		}
+	case "unloadwallet":
+		err := unloadWalletCmd.Parse(args[1:])
+		if err != nil {
+			cli.fail(err)
+		}
+	case "listwallets":
+		err := listWalletsCmd.Parse(args[1:])
+		if err != nil {
+			cli.fail(err)
+		}
+	case "backupwallet":
+		err := backupWalletCmd.Parse(args[1:])
+		if err != nil {
+			cli.fail(err)
+		}
+	case "splitbackup":
+		err := splitBackupCmd.Parse(args[1:])
+		if err != nil {
+			cli.fail(err)
+		}
+	case "recoverbackup":
+		err := recoverBackupCmd.Parse(args[1:])
+		if err != nil {
+			cli.fail(err)
+		}
+	case "listAddresses":
+		err := listAddressesCmd.Parse(args[1:])
+		if err != nil {
+			cli.fail(err)
+		}
+	case "listtransactions":
+		err := listTransactionsCmd.Parse(args[1:])
+		if err != nil {
+			cli.fail(err)
+		}
+	case "sendmany":
+		err := sendManyCmd.Parse(args[1:])
+		if err != nil {
+			cli.fail(err)
+		}
+	case "createrawtx":
+		err := createRawTxCmd.Parse(args[1:])
+		if err != nil {
+			cli.fail(err)
+		}
+	case "signrawtx":
+		err := signRawTxCmd.Parse(args[1:])
+		if err != nil {
+			cli.fail(err)
+		}
+	case "sendrawtx":
+		err := sendRawTxCmd.Parse(args[1:])
+		if err != nil {
+			cli.fail(err)
+		}
+	case "signmessage":
+		err := signMessageCmd.Parse(args[1:])
+		if err != nil {
+			cli.fail(err)
+		}
+	case "verifymessage":
+		err := verifyMessageCmd.Parse(args[1:])
+		if err != nil {
+			cli.fail(err)
+		}
+	case "listunspent":
+		err := listUnspentCmd.Parse(args[1:])
+		if err != nil {
+			cli.fail(err)
+		}
+	case "setlabel":
+		err := setLabelCmd.Parse(args[1:])
+		if err != nil {
+			cli.fail(err)
+		}
+	case "addcontact":
+		err := addContactCmd.Parse(args[1:])
+		if err != nil {
+			cli.fail(err)
+		}
+	case "removecontact":
+		err := removeContactCmd.Parse(args[1:])
+		if err != nil {
+			cli.fail(err)
+		}
+	case "listcontacts":
+		err := listContactsCmd.Parse(args[1:])
+		if err != nil {
+			cli.fail(err)
+		}
+	case "reindexutxo":
+		err := reindexUTXOCmd.Parse(args[1:])
+		if err != nil {
+			cli.fail(err)
+		}
+	case "listbanned":
+		err := listBannedCmd.Parse(args[1:])
+		if err != nil {
+			cli.fail(err)
+		}
+	case "setban":
+		err := setBanCmd.Parse(args[1:])
+		if err != nil {
+			cli.fail(err)
+		}
+	case "clearbanned":
+		err := clearBannedCmd.Parse(args[1:])
+		if err != nil {
+			cli.fail(err)
+		}
+	case "startnode":
+		err := startNodeCmd.Parse(args[1:])
+		if err != nil {
+			cli.fail(err)
+		}
+	default:
+		cli.printMenu()
+		runtime.Goexit()
+	}
+
+	if getBalanceCmd.Parsed() {
+		if *getBalanceAddress == "" {
+			getBalanceCmd.Usage()
+			runtime.Goexit()
+		}
+		if err := cli.getBalance(*getBalanceAddress, nodeId, *getBalanceWallet); err != nil {
+			cli.fail(err)
+		}
+	}
+
+	if initChainCmd.Parsed() {
+		if *createBlockchainAddress == "" {
+			initChainCmd.Usage()
+			runtime.Goexit()
+		}
+		if err := cli.createBlockChain(*createBlockchainAddress, nodeId); err != nil {
+			cli.fail(err)
+		}
+	}
+
+	if printCmd.Parsed() {
+		if err := cli.print(nodeId); err != nil {
+			cli.fail(err)
+		}
+	}
+
+	if createWalletCmd.Parsed() {
+		if err := cli.createWallet(nodeId, *createWalletWallet, *createWalletName, *createWalletBech32); err != nil {
+			cli.fail(err)
+		}
+	}
+
+	if loadWalletCmd.Parsed() {
+		if *loadWalletName == "" {
+			loadWalletCmd.Usage()
+			runtime.Goexit()
+		}
+		if err := cli.loadWallet(nodeId, *loadWalletName); err != nil {
+			cli.fail(err)
+		}
+	}
+
+	if unloadWalletCmd.Parsed() {
+		if *unloadWalletName == "" {
+			unloadWalletCmd.Usage()
+			runtime.Goexit()
+		}
+		if err := cli.unloadWallet(nodeId, *unloadWalletName); err != nil {
+			cli.fail(err)
+		}
+	}
+
+	if listWalletsCmd.Parsed() {
+		if err := cli.listWallets(nodeId); err != nil {
+			cli.fail(err)
+		}
+	}
+
+	if backupWalletCmd.Parsed() {
+		if *backupWalletDest == "" {
+			backupWalletCmd.Usage()
+			runtime.Goexit()
+		}
+		if err := cli.backupWallet(nodeId, *backupWalletWallet, *backupWalletDest); err != nil {
+			cli.fail(err)
+		}
+	}
+
+	if splitBackupCmd.Parsed() {
+		if *splitBackupShares <= 0 || *splitBackupThreshold <= 0 {
+			splitBackupCmd.Usage()
+			runtime.Goexit()
+		}
+		if err := cli.splitBackup(nodeId, *splitBackupWallet, *splitBackupShares, *splitBackupThreshold, *splitBackupDest); err != nil {
+			cli.fail(err)
+		}
+	}
+
+	if recoverBackupCmd.Parsed() {
+		if *recoverBackupName == "" || *recoverBackupShares == "" {
+			recoverBackupCmd.Usage()
+			runtime.Goexit()
+		}
+		if err := cli.recoverBackup(nodeId, *recoverBackupName, *recoverBackupShares); err != nil {
+			cli.fail(err)
+		}
+	}
+
+	if listAddressesCmd.Parsed() {
+		if err := cli.listAddresses(nodeId, *listAddressesWallet); err != nil {
+			cli.fail(err)
+		}
+	}
+
+	if listUnspentCmd.Parsed() {
+		if *listUnspentAddress == "" {
+			listUnspentCmd.Usage()
+			runtime.Goexit()
+		}
+		if err := cli.listUnspent(*listUnspentAddress, nodeId); err != nil {
+			cli.fail(err)
+		}
+	}
+
+	if setLabelCmd.Parsed() {
+		if *setLabelAddress == "" {
+			setLabelCmd.Usage()
+			runtime.Goexit()
+		}
+		if err := cli.setLabel(*setLabelAddress, *setLabelLabel, nodeId, *setLabelWallet); err != nil {
+			cli.fail(err)
+		}
+	}
+
+	if addContactCmd.Parsed() {
+		if *addContactAddress == "" || *addContactLabel == "" {
+			addContactCmd.Usage()
+			runtime.Goexit()
+		}
+		if err := cli.addContact(*addContactAddress, *addContactLabel, nodeId, *addContactWallet); err != nil {
+			cli.fail(err)
+		}
+	}
+
+	if removeContactCmd.Parsed() {
+		if *removeContactAddress == "" {
+			removeContactCmd.Usage()
+			runtime.Goexit()
+		}
+		if err := cli.removeContact(*removeContactAddress, nodeId, *removeContactWallet); err != nil {
+			cli.fail(err)
+		}
+	}
+
+	if listContactsCmd.Parsed() {
+		if err := cli.listContacts(nodeId, *listContactsWallet); err != nil {
+			cli.fail(err)
+		}
+	}
+
+	if listTransactionsCmd.Parsed() {
+		if err := cli.listTransactions(*listTransactionsAddress, nodeId, *listTransactionsWallet, *listTransactionsSkip, *listTransactionsCount); err != nil {
+			cli.fail(err)
+		}
+	}
+
+	if sendCmd.Parsed() {
+		if *fromAddress == "" || *toAddress == "" || *amount <= 0 {
+			sendCmd.Usage()
+			runtime.Goexit()
+		}
+
+		args := api.SendArgs{
+			Wallet:      *sendWallet,
+			Mine:        *sendMine,
+			ReuseChange: *sendReuseChange,
+			Strategy:    *sendStrategy,
+			Inputs:      *sendInputs,
+		}
+		if err := cli.send(*fromAddress, *toAddress, *amount, nodeId, args); err != nil {
+			cli.fail(err)
+		}
+	}
+	if sendManyCmd.Parsed() {
+		var recipients map[string]int
+		if *sendManyFrom == "" || json.Unmarshal([]byte(*sendManyAmounts), &recipients) != nil || len(recipients) == 0 {
+			sendManyCmd.Usage()
+			runtime.Goexit()
+		}
+		args := api.SendArgs{
+			From:        *sendManyFrom,
+			Amounts:     recipients,
+			Wallet:      *sendManyWallet,
+			Mine:        *sendManyMine,
+			ReuseChange: *sendManyReuseChange,
+			Strategy:    *sendManyStrategy,
+			Inputs:      *sendManyInputs,
+		}
+		if err := cli.sendMany(nodeId, args); err != nil {
+			cli.fail(err)
+		}
+	}
+	if createRawTxCmd.Parsed() {
+		recipients := make(map[string]int)
+		if *createRawTxAmounts != "" {
+			if err := json.Unmarshal([]byte(*createRawTxAmounts), &recipients); err != nil {
+				createRawTxCmd.Usage()
+				runtime.Goexit()
+			}
+		} else if *createRawTxTo != "" && *createRawTxAmount > 0 {
+			recipients[*createRawTxTo] = *createRawTxAmount
+		}
+		if *createRawTxFrom == "" || len(recipients) == 0 {
+			createRawTxCmd.Usage()
+			runtime.Goexit()
+		}
+		args := api.RawTxArgs{
+			From:     *createRawTxFrom,
+			Amounts:  recipients,
+			Change:   *createRawTxChange,
+			Strategy: *createRawTxStrategy,
+			Inputs:   *createRawTxInputs,
+		}
+		if err := cli.createRawTx(nodeId, args); err != nil {
+			cli.fail(err)
+		}
+	}
+	if signRawTxCmd.Parsed() {
+		if *signRawTxData == "" {
+			signRawTxCmd.Usage()
+			runtime.Goexit()
+		}
+		if err := cli.signRawTx(*signRawTxData, nodeId, *signRawTxWallet); err != nil {
+			cli.fail(err)
+		}
+	}
+	if sendRawTxCmd.Parsed() {
+		if *sendRawTxData == "" {
+			sendRawTxCmd.Usage()
+			runtime.Goexit()
+		}
+		if err := cli.sendRawTx(*sendRawTxData, nodeId, *sendRawTxMine); err != nil {
+			cli.fail(err)
+		}
+	}
+	if signMessageCmd.Parsed() {
+		if *signMessageAddress == "" {
+			signMessageCmd.Usage()
+			runtime.Goexit()
+		}
+		if err := cli.signMessage(*signMessageAddress, *signMessageMessage, nodeId, *signMessageWallet); err != nil {
+			cli.fail(err)
+		}
+	}
+	if verifyMessageCmd.Parsed() {
+		if *verifyMessageAddress == "" || *verifyMessageSignature == "" {
+			verifyMessageCmd.Usage()
+			runtime.Goexit()
+		}
+		if err := cli.verifyMessage(*verifyMessageAddress, *verifyMessageSignature, *verifyMessageMessage); err != nil {
+			cli.fail(err)
+		}
+	}
+	if reindexUTXOCmd.Parsed() {
+		if err := cli.reindexUTXO(nodeId); err != nil {
+			cli.fail(err)
+		}
+	}
+	if listBannedCmd.Parsed() {
+		if err := cli.listBanned(nodeId); err != nil {
+			cli.fail(err)
+		}
+	}
+	if setBanCmd.Parsed() {
+		if *setBanHost == "" {
+			setBanCmd.Usage()
+			runtime.Goexit()
+		}
+		args := api.SetBanArgs{Host: *setBanHost, Duration: *setBanDuration, Remove: *setBanRemove}
+		if err := cli.setBan(nodeId, args); err != nil {
+			cli.fail(err)
+		}
+	}
+	if clearBannedCmd.Parsed() {
+		if err := cli.clearBanned(nodeId); err != nil {
+			cli.fail(err)
+		}
+	}
+	if startNodeCmd.Parsed() {
+		connect, err := p2p.ParseAddressList(*startNodeConnect)
+		if err != nil {
+			cli.fail(err)
+		}
+		addNode, err := p2p.ParseAddressList(*startNodeAddNode)
+		if err != nil {
+			cli.fail(err)
+		}
+		err = cli.StartNode(daemon.Config{
+			NodeID:       nodeId,
+			ListenAddr:   *startNodeListen,
+			ExternalAddr: *startNodeExternal,
+			MinerAddress: *startNodeMiner,
+			APIAddr:      *startNodeAPI,
+			Connect:      connect,
+			AddNode:      addNode,
+
+			Encrypt:           *startNodeEncrypt,
+			RequireEncryption: *startNodeRequireEncryption,
+		})
+		if err != nil {
+			cli.fail(err)
+		}
+	}
+}
+
+func (cli *Command) reindexUTXO(nodeId string) error {
+	var reply api.ReindexReply
+	if err := cli.call(nodeId, "ReindexUTXO", api.Empty{}, &reply); err != nil {
+		return err
+	}
+	fmt.Printf("Done! There are %d transactions in the UTXO set.\n", reply.Transactions)
+	return nil
+}
+
+func (cli *Command) listBanned(nodeId string) error {
+	var reply api.BannedReply
+	if err := cli.call(nodeId, "ListBanned", api.Empty{}, &reply); err != nil {
+		return err
+	}
+	for _, ban := range reply.Bans {
+		fmt.Printf("%s until %s: %s\n", ban.Host, ban.Until.Format(time.RFC3339), ban.Reason)
+	}
+	return nil
+}
+
+func (cli *Command) setBan(nodeId string, args api.SetBanArgs) error {
+	if err := cli.call(nodeId, "SetBan", args, &api.Empty{}); err != nil {
+		return err
+	}
+	if args.Remove {
+		fmt.Printf("Lifted the ban of %s\n", args.Host)
+	} else {
+		fmt.Printf("Banned %s for %s\n", args.Host, args.Duration)
+	}
+	return nil
+}
+
+func (cli *Command) clearBanned(nodeId string) error {
+	if err := cli.call(nodeId, "ClearBanned", api.Empty{}, &api.Empty{}); err != nil {
+		return err
+	}
+	fmt.Println("Lifted every ban")
+	return nil
+}
+
+// StartNode runs the node in this process until it is interrupted
+func (cli *Command) StartNode(cfg daemon.Config) error {
+	fmt.Printf("Starting Node %s\n", cfg.NodeID)
+
+	if len(cfg.MinerAddress) > 0 {
+		if _, err := core.DecodeAddress(cfg.MinerAddress); err != nil {
+			return fmt.Errorf("wrong miner address: %w", err)
+		}
+		fmt.Println("Mining is on. Address to receive rewards: ", cfg.MinerAddress)
+	}
+	return daemon.Run(cfg)
+}
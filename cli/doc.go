@@ -0,0 +1,6 @@
+// Package cli implements the command line interface of a node, one command
+// per invocation. Commands call the running node over RPC, which holds the
+// chain and the loaded wallets. Only initChain, which makes the chain a node
+// starts on, and verifymessage, which needs no node, run on their own, and
+// signrawtx signs with the wallet file when no node runs.
+package cli
@@ -6,6 +6,7 @@ import { useNavigate } from "react-router-dom";
 
 const Addresses = () => {
   const [list, setList] = useState([]);
+  const [labels, setLabels] = useState({});
   const [open, setOpen] = useState(false);
   const [address, setAddress] = useState("");
   const [openC, setOpenC] = useState(false);
@@ -15,6 +16,7 @@ const Addresses = () => {
     (async () => {
       const res = await axios.get("http://localhost:8080/listaddresses");
       setList(res.data.data || []);
+      setLabels(res.data.labels || {});
     })();
   }, []);
 
@@ -80,6 +82,11 @@ const Addresses = () => {
             }}
           >
             {index + 1}. {item}
+            {labels[item] && (
+              <Typography component="span" sx={{ ml: 2, fontWeight: "bold" }}>
+                {labels[item]}
+              </Typography>
+            )}
           </Box>
         );
       })}
@@ -0,0 +1,10 @@
+// Command cli runs a single wallet or chain command against the local node
+// data, see the cli package for the list.
+package main
+
+import "minhlpc/build_blockchain/cli"
+
+func main() {
+	command := cli.Command{}
+	command.Run()
+}
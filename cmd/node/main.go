@@ -0,0 +1,98 @@
+// Command node runs a full node with its REST API, the command line client
+// talks to it over RPC.
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+
+	"minhlpc/build_blockchain/core"
+	"minhlpc/build_blockchain/daemon"
+	"minhlpc/build_blockchain/p2p"
+	"minhlpc/build_blockchain/wallet"
+)
+
+func main() {
+	network := flag.String("network", core.MainNetParams.Name, "Network to run on: mainnet, testnet or regtest")
+	dataDir := flag.String("datadir", ".", "Directory holding the chain, wallets and address book")
+	nodeIdFlag := flag.String("nodeid", "", "Name of the node files, the default port of the network when empty")
+	listen := flag.String("listen", "", "Address to accept peers on, :<default port> when empty")
+	externalAddr := flag.String("externaladdr", "", "Address peers reach this node on, the listen address when empty")
+	miner := flag.String("miner", "", "Enable mining mode and send reward to ADDRESS")
+	apiAddr := flag.String("api", ":8080", "Address the REST API listens on")
+	connect := flag.String("connect", "", "Connect only to these nodes: host:port,...")
+	addNode := flag.String("addnode", "", "Stay connected to these nodes next to the ones of the address book: host:port,...")
+	encrypt := flag.Bool("encrypt", false, "Connect to peers over TLS, in plaintext to peers without it")
+	requireEncryption := flag.Bool("requireencryption", false, "Only talk to peers over TLS")
+	flag.Parse()
+	if err := p2p.SelectNetwork(*network); err != nil {
+		log.Panic(err)
+	}
+	core.SetDataDir(*dataDir)
+	connectNodes, err := p2p.ParseAddressList(*connect)
+	if err != nil {
+		log.Panicf("wrong -connect: %s", err)
+	}
+	addNodes, err := p2p.ParseAddressList(*addNode)
+	if err != nil {
+		log.Panicf("wrong -addnode: %s", err)
+	}
+
+	nodeId := *nodeIdFlag
+	if nodeId == "" {
+		nodeId = core.ActiveNet().DefaultPort
+	}
+	listenAddr := *listen
+	if listenAddr == "" {
+		listenAddr = ":" + core.ActiveNet().DefaultPort
+	}
+	wallets, err := wallet.CreateWallets(nodeId)
+	if err != nil && !os.IsNotExist(err) {
+		log.Panic(err)
+	}
+	var address string
+	err = wallets.Update(nodeId, func() error {
+		address = wallets.AddWallets()
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	chain, err := core.InitMyChain(address, nodeId)
+	if errors.Is(err, core.ErrChainExists) {
+		chain, err = core.LoadBlockchain(nodeId)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+
+	u := core.UTXOSet{Blockchain: chain}
+	err = u.Reindex()
+	chain.Database.Close()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if *miner != "" {
+		if _, err := core.DecodeAddress(*miner); err != nil {
+			log.Panicf("wrong miner address: %s", err)
+		}
+	}
+	err = daemon.Run(daemon.Config{
+		NodeID:       nodeId,
+		ListenAddr:   listenAddr,
+		ExternalAddr: *externalAddr,
+		MinerAddress: *miner,
+		APIAddr:      *apiAddr,
+		Connect:      connectNodes,
+		AddNode:      addNodes,
+
+		Encrypt:           *encrypt,
+		RequireEncryption: *requireEncryption,
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
@@ -0,0 +1,145 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"golang.org/x/crypto/ripemd160"
+
+	"github.com/mr-tron/base58"
+)
+
+const (
+	// ChecksumLength is the number of CheckSum bytes appended to an address
+	ChecksumLength = 4
+	base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+)
+
+// CheckSum is the first bytes of the double SHA-256 of payload
+func CheckSum(payload []byte) []byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	return second[:ChecksumLength]
+}
+
+// PublicKeyHash is the RIPEMD-160 of the SHA-256 of a public key, outputs
+// are locked to it
+func PublicKeyHash(pubKey []byte) []byte {
+	pubKeyHash := sha256.Sum256(pubKey)
+
+	hasher := ripemd160.New()
+
+	_, err := hasher.Write(pubKeyHash[:])
+
+	if err != nil {
+		log.Panic(err)
+	}
+	publicRipemd160 := hasher.Sum(nil)
+
+	return publicRipemd160
+}
+
+func Base58Encode(input []byte) []byte {
+	encode := base58.Encode(input)
+	return []byte(encode)
+}
+
+func Base58Decode(input []byte) ([]byte, error) {
+	return base58.Decode(string(input[:]))
+}
+
+// PubKeyHashToAddress encodes a public key hash (as stored in outputs) back
+// into its Base58Check address
+func PubKeyHashToAddress(pubKeyHash []byte) string {
+	versionH := append([]byte{activeNet.AddressVersion}, pubKeyHash...)
+	checkSum := CheckSum(versionH)
+
+	fullH := append(versionH, checkSum...)
+	return string(Base58Encode(fullH))
+}
+
+var (
+	ErrAddressCharacter = errors.New("invalid character")
+	ErrAddressChecksum  = errors.New("checksum mismatch")
+	ErrAddressLength    = errors.New("invalid length")
+	ErrAddressFormat    = errors.New("invalid format")
+	ErrAddressMixedCase = errors.New("mixed upper and lower case")
+	ErrAddressNetwork   = errors.New("address belongs to another network")
+	ErrAddressVersion   = errors.New("unknown address version")
+)
+
+// AddressError says why an address was rejected and, when it is known, at
+// which character. Kind is one of the ErrAddress values.
+type AddressError struct {
+	Address  string
+	Kind     error
+	Position int
+}
+
+func (e *AddressError) Error() string {
+	if e.Position >= 0 && e.Position < len(e.Address) {
+		return fmt.Sprintf("address %s: %s at position %d (%q)", e.Address, e.Kind, e.Position, e.Address[e.Position])
+	}
+	return fmt.Sprintf("address %s: %s", e.Address, e.Kind)
+}
+
+func (e *AddressError) Unwrap() error {
+	return e.Kind
+}
+
+// Is makes every address error an ErrInvalidAddress, next to its Kind
+func (e *AddressError) Is(target error) bool {
+	return target == ErrInvalidAddress
+}
+
+// PubKeyHashLength is the size of a public key hash
+const PubKeyHashLength = 20
+
+// DecodeAddress checks a Base58Check or bech32 address of the active network
+// and returns its public key hash. Errors are *AddressError values.
+func DecodeAddress(address string) ([]byte, error) {
+	if address == "" {
+		return nil, &AddressError{address, ErrAddressLength, -1}
+	}
+	if IsBech32Address(address) {
+		return decodeBech32Address(address)
+	}
+
+	for i, c := range address {
+		if !strings.ContainsRune(base58Alphabet, c) {
+			return nil, &AddressError{address, ErrAddressCharacter, i}
+		}
+	}
+	decoded, err := base58.Decode(address)
+	if err != nil {
+		return nil, &AddressError{address, ErrAddressFormat, -1}
+	}
+	if len(decoded) != 1+PubKeyHashLength+ChecksumLength {
+		return nil, &AddressError{address, ErrAddressLength, -1}
+	}
+
+	actualChecksum := decoded[len(decoded)-ChecksumLength:]
+	version := decoded[0]
+	pubKeyHash := decoded[1 : len(decoded)-ChecksumLength]
+	targetChecksum := CheckSum(append([]byte{version}, pubKeyHash...))
+
+	if !bytes.Equal(actualChecksum, targetChecksum) {
+		return nil, &AddressError{address, ErrAddressChecksum, -1}
+	}
+	// a well formed address of another network is still not valid here
+	if version != activeNet.AddressVersion {
+		return nil, &AddressError{address, ErrAddressNetwork, -1}
+	}
+
+	return pubKeyHash, nil
+}
+
+// ValidateAddress tells whether DecodeAddress accepts address
+func ValidateAddress(address string) bool {
+	_, err := DecodeAddress(address)
+	return err == nil
+}
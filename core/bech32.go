@@ -0,0 +1,220 @@
+package core
+
+import (
+	"errors"
+	"log"
+	"strings"
+)
+
+// Bech32 encoding as described in BIP 173. Its checksum is a BCH code that
+// detects any 4 character errors and lets us point at a single mistyped
+// character.
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+const (
+	bech32ChecksumLength = 6
+	bech32MaxLength      = 90
+)
+
+var bech32Generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+func bech32Polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= bech32Generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HrpExpand(hrp string) []byte {
+	expanded := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]>>5)
+	}
+	expanded = append(expanded, 0)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]&31)
+	}
+	return expanded
+}
+
+func bech32VerifyChecksum(hrp string, data []byte) bool {
+	return bech32Polymod(append(bech32HrpExpand(hrp), data...)) == 1
+}
+
+func bech32CreateChecksum(hrp string, data []byte) []byte {
+	values := append(bech32HrpExpand(hrp), data...)
+	values = append(values, make([]byte, bech32ChecksumLength)...)
+	mod := bech32Polymod(values) ^ 1
+
+	checksum := make([]byte, bech32ChecksumLength)
+	for i := range checksum {
+		checksum[i] = byte(mod>>uint(5*(5-i))) & 31
+	}
+	return checksum
+}
+
+// Bech32Encode encodes 5 bit groups under the human readable part hrp
+func Bech32Encode(hrp string, data []byte) string {
+	combined := append(append([]byte{}, data...), bech32CreateChecksum(hrp, data)...)
+
+	var encoded strings.Builder
+	encoded.WriteString(hrp)
+	encoded.WriteByte('1')
+	for _, v := range combined {
+		encoded.WriteByte(bech32Charset[v])
+	}
+	return encoded.String()
+}
+
+// Bech32Decode splits a bech32 string into its human readable part and its
+// 5 bit data groups, without the checksum. Errors are *AddressError values
+// with the position of the offending character when it is known.
+func Bech32Decode(s string) (string, []byte, error) {
+	if len(s) > bech32MaxLength {
+		return "", nil, &AddressError{s, ErrAddressLength, -1}
+	}
+
+	lower, upper := false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 33 || c > 126 {
+			return "", nil, &AddressError{s, ErrAddressCharacter, i}
+		}
+		lower = lower || (c >= 'a' && c <= 'z')
+		upper = upper || (c >= 'A' && c <= 'Z')
+	}
+	if lower && upper {
+		return "", nil, &AddressError{s, ErrAddressMixedCase, -1}
+	}
+	s = strings.ToLower(s)
+
+	sep := strings.LastIndexByte(s, '1')
+	if sep < 1 || sep+bech32ChecksumLength+1 > len(s) {
+		return "", nil, &AddressError{s, ErrAddressFormat, sep}
+	}
+	hrp := s[:sep]
+
+	data := make([]byte, 0, len(s)-sep-1)
+	for i := sep + 1; i < len(s); i++ {
+		v := strings.IndexByte(bech32Charset, s[i])
+		if v < 0 {
+			return "", nil, &AddressError{s, ErrAddressCharacter, i}
+		}
+		data = append(data, byte(v))
+	}
+
+	if !bech32VerifyChecksum(hrp, data) {
+		return "", nil, &AddressError{s, ErrAddressChecksum, bech32LocateError(hrp, data, sep+1)}
+	}
+
+	return hrp, data[:len(data)-bech32ChecksumLength], nil
+}
+
+// bech32LocateError looks for the single character whose replacement makes
+// the checksum valid. It returns -1 when there is none or more than one, that
+// is when the string has more than one error.
+func bech32LocateError(hrp string, data []byte, offset int) int {
+	found := -1
+	candidate := append([]byte{}, data...)
+	for i := range candidate {
+		original := candidate[i]
+		for v := byte(0); v < 32; v++ {
+			if v == original {
+				continue
+			}
+			candidate[i] = v
+			if bech32VerifyChecksum(hrp, candidate) {
+				if found >= 0 {
+					return -1
+				}
+				found = offset + i
+			}
+		}
+		candidate[i] = original
+	}
+	return found
+}
+
+// convertBits regroups a byte slice from groups of fromBits to toBits bits
+func convertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	acc := uint32(0)
+	bits := uint(0)
+	maxv := uint32(1)<<toBits - 1
+	var converted []byte
+
+	for _, v := range data {
+		if uint32(v)>>fromBits != 0 {
+			return nil, errors.New("invalid data range")
+		}
+		acc = acc<<fromBits | uint32(v)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			converted = append(converted, byte(acc>>bits&maxv))
+		}
+	}
+
+	if pad {
+		if bits > 0 {
+			converted = append(converted, byte(acc<<(toBits-bits)&maxv))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxv != 0 {
+		return nil, errors.New("invalid padding")
+	}
+
+	return converted, nil
+}
+
+// bech32AddressVersion is the first 5 bit group of every bech32 address, it
+// leaves room for other kinds of addresses later
+const bech32AddressVersion = 0
+
+// PubKeyHashToBech32 encodes a public key hash as a bech32 address of the
+// active network
+func PubKeyHashToBech32(pubKeyHash []byte) string {
+	data, err := convertBits(pubKeyHash, 8, 5, true)
+	if err != nil {
+		log.Panic(err)
+	}
+	return Bech32Encode(activeNet.Bech32HRP, append([]byte{bech32AddressVersion}, data...))
+}
+
+func decodeBech32Address(address string) ([]byte, error) {
+	hrp, data, err := Bech32Decode(address)
+	if err != nil {
+		return nil, err
+	}
+	if hrp != activeNet.Bech32HRP {
+		return nil, &AddressError{address, ErrAddressNetwork, -1}
+	}
+	if len(data) < 1 || data[0] != bech32AddressVersion {
+		return nil, &AddressError{address, ErrAddressVersion, len(hrp) + 1}
+	}
+
+	pubKeyHash, err := convertBits(data[1:], 5, 8, false)
+	if err != nil || len(pubKeyHash) != PubKeyHashLength {
+		return nil, &AddressError{address, ErrAddressLength, -1}
+	}
+	return pubKeyHash, nil
+}
+
+// IsBech32Address tells the two address formats apart by the human readable
+// part of one of the known networks. Bech32 is either all lower or all upper
+// case, so a Base58 address can not be mistaken for it.
+func IsBech32Address(address string) bool {
+	for _, params := range networks {
+		prefix := params.Bech32HRP + "1"
+		if strings.HasPrefix(address, prefix) || strings.HasPrefix(address, strings.ToUpper(prefix)) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,8 +1,9 @@
-package main
+package core
 
 import (
 	"bytes"
 	"encoding/gob"
+	"fmt"
 	"log"
 	"time"
 )
@@ -53,14 +54,14 @@ func (block *Block) Serialize() []byte {
 	return res.Bytes()
 }
 
-func Deserialize(data []byte) *Block {
+func Deserialize(data []byte) (*Block, error) {
 	var block Block
 
 	decoder := gob.NewDecoder(bytes.NewReader(data))
 	err := decoder.Decode(&block)
 	if err != nil {
-		log.Panic(err)
+		return nil, fmt.Errorf("%w: %s", ErrInvalidBlock, err)
 	}
 
-	return &block
+	return &block, nil
 }
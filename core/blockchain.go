@@ -1,4 +1,4 @@
-package main
+package core
 
 import (
 	"bytes"
@@ -9,18 +9,39 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"runtime"
 	"strings"
+	"sync"
 
 	"github.com/dgraph-io/badger"
 )
 
-const dbPath = "./db/blocks_%s"
-const genesisData = "First Transaction from Genesis"
-
+// BlockChain is shared by every goroutine of a node. Its methods do not lock,
+// callers wrap them in View or Update so that the blocks and the indexes kept
+// next to them are always seen in step.
 type BlockChain struct {
 	LatestHash []byte
 	Database   *badger.DB
+
+	lock sync.RWMutex
+}
+
+// View runs read with the chain locked for reading
+func (chain *BlockChain) View(read func() error) error {
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+	return read()
+}
+
+// Update runs change with the chain locked for writing, no other goroutine
+// reads the chain until change returns
+func (chain *BlockChain) Update(change func() error) error {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+	return change()
+}
+
+func dbPath(nodeId string) string {
+	return NetPath("./db", fmt.Sprintf("blocks_%s", nodeId))
 }
 
 func isDbExisted(path string) bool {
@@ -31,177 +52,153 @@ func isDbExisted(path string) bool {
 	return true
 }
 
-func InitBlockChain(address, nodeId string) *BlockChain {
-	path := fmt.Sprintf(dbPath, nodeId)
-	if isDbExisted(path) {
-		fmt.Println("Blockchain already exists")
-		runtime.Goexit()
+func InitBlockChain(address, nodeId string) (*BlockChain, error) {
+	return createBlockChain(address, nodeId, Genesis)
+}
+
+func LoadBlockchain(address string) (*BlockChain, error) {
+	path := dbPath(address)
+	if !isDbExisted(path) {
+		return nil, ErrChainNotFound
 	}
+
 	var lastHash []byte
+
 	opts := badger.DefaultOptions
 	opts.Dir = path
 	opts.ValueDir = path
 
 	db, err := openDB(path, opts)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
 	err = db.Update(func(txn *badger.Txn) error {
-		cbtx := CreateCoinbaseTx(address, genesisData)
-		genesis := Genesis(cbtx)
-		fmt.Println("Genesis created")
-		err = txn.Set(genesis.Hash, genesis.Serialize())
+		item, err := txn.Get([]byte("latestHash"))
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
-		err = txn.Set([]byte("latestHash"), genesis.Hash)
-
-		lastHash = genesis.Hash
+		lastHash, err = item.Value()
 
 		return err
-
 	})
-
 	if err != nil {
-		log.Panic(err)
+		db.Close()
+		return nil, err
 	}
 
-	blockchain := BlockChain{lastHash, db}
-	return &blockchain
+	return &BlockChain{LatestHash: lastHash, Database: db}, nil
 }
 
-func LoadBlockchain(address string) *BlockChain {
-	path := fmt.Sprintf(dbPath, address)
-	if !isDbExisted(path) {
-		fmt.Println("Please init your blockchain first!")
-		runtime.Goexit()
+// getBlock reads a block inside a database transaction
+func getBlock(txn *badger.Txn, hash []byte) (*Block, error) {
+	item, err := txn.Get(hash)
+	if err == badger.ErrKeyNotFound {
+		return nil, fmt.Errorf("%w: %x", ErrBlockNotFound, hash)
+	}
+	if err != nil {
+		return nil, err
+	}
+	blockData, err := item.Value()
+	if err != nil {
+		return nil, err
 	}
 
-	var lastHash []byte
-
-	opts := badger.DefaultOptions
-	opts.Dir = path
-	opts.ValueDir = path
+	return Deserialize(blockData)
+}
 
-	db, err := openDB(path, opts)
+// getLastBlock reads the block latestHash points to
+func getLastBlock(txn *badger.Txn) (*Block, error) {
+	item, err := txn.Get([]byte("latestHash"))
 	if err != nil {
-		log.Panic(err)
+		return nil, err
+	}
+	lastHash, err := item.Value()
+	if err != nil {
+		return nil, err
 	}
 
-	err = db.Update(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("latestHash"))
-		if err != nil {
-			log.Panic(err)
-		}
-		lastHash, err = item.Value()
+	return getBlock(txn, lastHash)
+}
 
+func (chain *BlockChain) AddBlock(block *Block) error {
+	var latest bool
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		var err error
+		latest, err = putBlock(txn, block)
 		return err
 	})
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
-
-	chain := BlockChain{lastHash, db}
-
-	return &chain
+	if latest {
+		chain.LatestHash = block.Hash
+	}
+	return nil
 }
 
-func (chain *BlockChain) AddBlock(block *Block) {
-	err := chain.Database.Update(func(txn *badger.Txn) error {
-		if _, err := txn.Get(block.Hash); err == nil {
-			return nil
-		}
-
-		blockData := block.Serialize()
-		err := txn.Set(block.Hash, blockData)
-		if err != nil {
-			log.Panic(err)
-		}
-
-		item, err := txn.Get([]byte("latestHash"))
-		if err != nil {
-			log.Panic(err)
-		}
-		lastHash, _ := item.Value()
-
-		item, err = txn.Get(lastHash)
-		if err != nil {
-			log.Panic(err)
-		}
-		lastBlockData, _ := item.Value()
-
-		lastBlock := Deserialize(lastBlockData)
+// putBlock stores a block inside a database transaction unless it is stored
+// already, latest is true when it became the newest block
+func putBlock(txn *badger.Txn, block *Block) (latest bool, err error) {
+	if _, err := txn.Get(block.Hash); err == nil {
+		return false, nil
+	}
 
-		if block.Height > lastBlock.Height {
-			err = txn.Set([]byte("latestHash"), block.Hash)
-			if err != nil {
-				log.Panic(err)
-			}
-			chain.LatestHash = block.Hash
-		}
+	if err := txn.Set(block.Hash, block.Serialize()); err != nil {
+		return false, err
+	}
 
-		return nil
-	})
+	lastBlock, err := getLastBlock(txn)
 	if err != nil {
-		log.Panic(err)
+		return false, err
+	}
+
+	if block.Height <= lastBlock.Height {
+		return false, nil
 	}
+	return true, txn.Set([]byte("latestHash"), block.Hash)
 }
 
-func (chain *BlockChain) GetBestHeight() int {
-	var lastBlock Block
+func (chain *BlockChain) GetBestHeight() (int, error) {
+	var lastBlock *Block
 
 	err := chain.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("latestHash"))
-		if err != nil {
-			log.Panic(err)
-		}
-		lastHash, _ := item.Value()
-
-		item, err = txn.Get(lastHash)
-		if err != nil {
-			log.Panic(err)
-		}
-		lastBlockData, _ := item.Value()
-
-		lastBlock = *Deserialize(lastBlockData)
-
-		return nil
+		var err error
+		lastBlock, err = getLastBlock(txn)
+		return err
 	})
 	if err != nil {
-		log.Panic(err)
+		return 0, err
 	}
 
-	return lastBlock.Height
+	return lastBlock.Height, nil
 }
 
 func (chain *BlockChain) GetBlock(blockHash []byte) (Block, error) {
-	var block Block
+	var block *Block
 
 	err := chain.Database.View(func(txn *badger.Txn) error {
-		if item, err := txn.Get(blockHash); err != nil {
-			return errors.New("Block is not found")
-		} else {
-			blockData, _ := item.Value()
-
-			block = *Deserialize(blockData)
-		}
-		return nil
+		var err error
+		block, err = getBlock(txn, blockHash)
+		return err
 	})
 	if err != nil {
-		return block, err
+		return Block{}, err
 	}
 
-	return block, nil
+	return *block, nil
 }
 
-func (chain *BlockChain) GetBlockHashes() [][]byte {
+func (chain *BlockChain) GetBlockHashes() ([][]byte, error) {
 	var blocks [][]byte
 
 	iter := chain.Iterator()
 
 	for {
-		block := iter.Next()
+		block, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
 
 		blocks = append(blocks, block.Hash)
 
@@ -210,71 +207,61 @@ func (chain *BlockChain) GetBlockHashes() [][]byte {
 		}
 	}
 
-	return blocks
+	return blocks, nil
 }
 
-func (chain *BlockChain) MineBlock(transactions []*Transaction) *Block {
+// MineBlock mines a block of transactions on the newest block. The
+// transactions are checked as those of a block from a peer, and the block is
+// added together with its changes to the UTXO set and the history in one
+// database transaction. The caller holds the chain for writing.
+func (chain *BlockChain) MineBlock(transactions []*Transaction) (*Block, error) {
 	var lastHash []byte
 	var lastHeight int
 
-	for _, tx := range transactions {
-		if chain.VerifyTransaction(tx) != true {
-			log.Panic("Invalid Transaction")
-		}
-	}
-
 	err := chain.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("latestHash"))
-		if err != nil {
-			log.Panic(err)
-		}
-		lastHash, err = item.Value()
-
-		item, err = txn.Get(lastHash)
+		lastBlock, err := getLastBlock(txn)
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
-		lastBlockData, _ := item.Value()
-
-		lastBlock := Deserialize(lastBlockData)
-
+		lastHash = lastBlock.Hash
 		lastHeight = lastBlock.Height
 
-		return err
+		return nil
 	})
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
 	newBlock := CreateBlock(transactions, lastHash, lastHeight+1)
-
-	err = chain.Database.Update(func(txn *badger.Txn) error {
-		err := txn.Set(newBlock.Hash, newBlock.Serialize())
-		if err != nil {
-			log.Panic(err)
-		}
-		err = txn.Set([]byte("latestHash"), newBlock.Hash)
-
-		chain.LatestHash = newBlock.Hash
-
-		return err
-	})
-	if err != nil {
-		log.Panic(err)
+	if err := chain.checkTransactions(newBlock); err != nil {
+		return nil, err
 	}
 
-	return newBlock
+	UTXOSet := UTXOSet{Blockchain: chain}
+	if err := UTXOSet.connect(newBlock); err != nil {
+		return nil, err
+	}
+	return newBlock, nil
 }
 
 func CreateGenesisBlock(CoinbaseTx *Transaction) *Block {
 	return CreateBlock([]*Transaction{CoinbaseTx}, []byte{}, 0)
 }
 
-func InitMyChain(address, nodeId string) *BlockChain {
-	path := fmt.Sprintf(dbPath, nodeId)
+func InitMyChain(address, nodeId string) (*BlockChain, error) {
+	return createBlockChain(address, nodeId, CreateGenesisBlock)
+}
+
+// createBlockChain makes the database of a new chain whose genesis block pays
+// address
+func createBlockChain(address, nodeId string, genesis func(*Transaction) *Block) (*BlockChain, error) {
+	path := dbPath(nodeId)
 	if isDbExisted(path) {
-		fmt.Println("Your blockchain is already running")
-		runtime.Goexit()
+		return nil, ErrChainExists
+	}
+	coinbaseTx, err := CreateCoinbaseTx(address, activeNet.GenesisData)
+	if err != nil {
+		return nil, err
 	}
 	var latestHash []byte
 
@@ -285,15 +272,14 @@ func InitMyChain(address, nodeId string) *BlockChain {
 	db, err := openDB(path, opts)
 
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
 	err = db.Update(func(txn *badger.Txn) error {
-		coinbaseTx := CreateCoinbaseTx(address, "Genesis data")
-		genesis := CreateGenesisBlock(coinbaseTx)
+		genesis := genesis(coinbaseTx)
 		err = txn.Set(genesis.Hash, genesis.Serialize())
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
 		err = txn.Set([]byte("latestHash"), genesis.Hash)
 
@@ -302,12 +288,13 @@ func InitMyChain(address, nodeId string) *BlockChain {
 		return err
 	})
 	if err != nil {
-		log.Panic(err)
+		db.Close()
+		return nil, err
 	}
-	return &BlockChain{latestHash, db}
+	return &BlockChain{LatestHash: latestHash, Database: db}, nil
 }
 
-func (chain *BlockChain) FindUnspentTxs(pubKey []byte) []Transaction {
+func (chain *BlockChain) FindUnspentTxs(pubKey []byte) ([]Transaction, error) {
 	var unspentTxs []Transaction
 
 	spentTXOs := make(map[string][]int)
@@ -315,7 +302,10 @@ func (chain *BlockChain) FindUnspentTxs(pubKey []byte) []Transaction {
 	iter := chain.Iterator()
 
 	for {
-		block := iter.Next()
+		block, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
 
 		for _, tx := range block.Transactions {
 			txID := hex.EncodeToString(tx.Id)
@@ -347,12 +337,15 @@ func (chain *BlockChain) FindUnspentTxs(pubKey []byte) []Transaction {
 			break
 		}
 	}
-	return unspentTxs
+	return unspentTxs, nil
 }
 
-func (chain *BlockChain) FindUTXOs(pubKey []byte) []TxOutput {
+func (chain *BlockChain) FindUTXOs(pubKey []byte) ([]TxOutput, error) {
 	var UTXOs []TxOutput
-	unspentTxs := chain.FindUnspentTxs(pubKey)
+	unspentTxs, err := chain.FindUnspentTxs(pubKey)
+	if err != nil {
+		return nil, err
+	}
 
 	for _, tx := range unspentTxs {
 		for _, out := range tx.TxOutputs {
@@ -361,17 +354,20 @@ func (chain *BlockChain) FindUTXOs(pubKey []byte) []TxOutput {
 			}
 		}
 	}
-	return UTXOs
+	return UTXOs, nil
 }
 
-func (chain *BlockChain) FindUTXO() map[string]TxOutputs {
+func (chain *BlockChain) FindUTXO() (map[string]TxOutputs, error) {
 	UTXO := make(map[string]TxOutputs)
 	spentTXOs := make(map[string][]int)
 
 	iter := chain.Iterator()
 
 	for {
-		block := iter.Next()
+		block, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
 
 		for _, tx := range block.Transactions {
 			txID := hex.EncodeToString(tx.Id)
@@ -387,6 +383,7 @@ func (chain *BlockChain) FindUTXO() map[string]TxOutputs {
 				}
 				outs := UTXO[txID]
 				outs.Outputs = append(outs.Outputs, out)
+				outs.Indexes = append(outs.Indexes, outIdx)
 				UTXO[txID] = outs
 			}
 			if tx.IsCoinbase() == false {
@@ -401,12 +398,15 @@ func (chain *BlockChain) FindUTXO() map[string]TxOutputs {
 			break
 		}
 	}
-	return UTXO
+	return UTXO, nil
 }
 
-func (chain *BlockChain) FindSpendableOutputs(pubKey []byte, amount int) (int, map[string][]int) {
+func (chain *BlockChain) FindSpendableOutputs(pubKey []byte, amount int) (int, map[string][]int, error) {
 	unspentOuts := make(map[string][]int)
-	unspentTxs := chain.FindUnspentTxs(pubKey)
+	unspentTxs, err := chain.FindUnspentTxs(pubKey)
+	if err != nil {
+		return 0, nil, err
+	}
 	accumulated := 0
 
 Collect:
@@ -425,7 +425,7 @@ Collect:
 		}
 	}
 
-	return accumulated, unspentOuts
+	return accumulated, unspentOuts, nil
 }
 
 func retry(dir string, originalOpts badger.Options) (*badger.DB, error) {
@@ -467,33 +467,31 @@ func (chain *BlockChain) Iterator() *BlockChainIterator {
 	return iter
 }
 
-func (iter *BlockChainIterator) Next() *Block {
+func (iter *BlockChainIterator) Next() (*Block, error) {
 	var block *Block
 
 	err := iter.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(iter.CurrentHash)
-		if err != nil {
-			log.Panic(err)
-		}
-		encodedBlock, err := item.Value()
-		block = Deserialize(encodedBlock)
-
+		var err error
+		block, err = getBlock(txn, iter.CurrentHash)
 		return err
 	})
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
 	iter.CurrentHash = block.PreviousHash
 
-	return block
+	return block, nil
 }
 
 func (bc *BlockChain) FindTransaction(ID []byte) (Transaction, error) {
 	iter := bc.Iterator()
 
 	for {
-		block := iter.Next()
+		block, err := iter.Next()
+		if err != nil {
+			return Transaction{}, err
+		}
 
 		for _, tx := range block.Transactions {
 			if bytes.Compare(tx.Id, ID) == 0 {
@@ -506,35 +504,56 @@ func (bc *BlockChain) FindTransaction(ID []byte) (Transaction, error) {
 		}
 	}
 
-	return Transaction{}, errors.New("Transaction does not exist")
+	return Transaction{}, fmt.Errorf("%w: %x", ErrTxNotFound, ID)
 }
 
-func (blockchain *BlockChain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) {
+func (blockchain *BlockChain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) error {
+	prevTXs, err := blockchain.PreviousTransactions(tx)
+	if err != nil {
+		return err
+	}
+	return tx.Sign(privKey, prevTXs)
+}
+
+func (blockchain *BlockChain) SignTransactionWithKeys(tx *Transaction, privKeys map[string]ecdsa.PrivateKey) error {
+	prevTXs, err := blockchain.PreviousTransactions(tx)
+	if err != nil {
+		return err
+	}
+	return tx.SignWithKeys(privKeys, prevTXs)
+}
+
+// PreviousTransactions looks up the transactions whose outputs tx spends, by
+// their hex id
+func (blockchain *BlockChain) PreviousTransactions(tx *Transaction) (map[string]Transaction, error) {
 	previousTransaction := make(map[string]Transaction)
 	for _, in := range tx.TxInputs {
 		prevTX, err := blockchain.FindTransaction(in.Id)
 		if err != nil {
-			log.Panic(err)
+			return nil, err
 		}
 		previousTransaction[hex.EncodeToString(prevTX.Id)] = prevTX
 	}
 
-	tx.Sign(privKey, previousTransaction)
+	return previousTransaction, nil
 }
 
-func (bc *BlockChain) VerifyTransaction(tx *Transaction) bool {
+// VerifyTransaction checks the signatures of tx against the outputs it
+// spends, errors wrap ErrInvalidTx
+func (bc *BlockChain) VerifyTransaction(tx *Transaction) error {
 	if tx.IsCoinbase() {
-		return true
+		return nil
 	}
 
-	prevTXs := make(map[string]Transaction)
-
-	for _, in := range tx.TxInputs {
-		prevTX, err := bc.FindTransaction(in.Id)
-		if err != nil {
-			log.Panic(err)
-		}
-		prevTXs[hex.EncodeToString(prevTX.Id)] = prevTX
+	prevTXs, err := bc.PreviousTransactions(tx)
+	if errors.Is(err, ErrTxNotFound) {
+		return fmt.Errorf("%w: %s", ErrInvalidTx, err)
+	}
+	if err != nil {
+		return err
+	}
+	if !tx.Verify(prevTXs) {
+		return fmt.Errorf("%w: %x has an invalid signature", ErrInvalidTx, tx.Id)
 	}
-	return tx.Verify(prevTXs)
+	return nil
 }
@@ -0,0 +1,49 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestMineBlockRefusesSpentInputs(t *testing.T) {
+	useRegtest(t)
+	key := newTestKey(t)
+	other := newTestKey(t)
+	chain := newTestChain(t, key)
+
+	genesis, err := chain.GetBlock(chain.LatestHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	funds := genesis.Transactions[0]
+
+	block, err := chain.MineBlock([]*Transaction{spend(t, key, funds, 0, BlockReward, other.address)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = chain.MineBlock([]*Transaction{spend(t, key, funds, 0, BlockReward, key.address)})
+	if !errors.Is(err, ErrInvalidTx) {
+		t.Fatalf("got %v, want an invalid transaction", err)
+	}
+	if !bytes.Equal(chain.LatestHash, block.Hash) {
+		t.Fatalf("tip moved to %x", chain.LatestHash)
+	}
+	bestHeight, err := chain.GetBestHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bestHeight != 1 {
+		t.Fatalf("best height is %d, want 1", bestHeight)
+	}
+
+	UTXOSet := UTXOSet{Blockchain: chain}
+	balance, err := UTXOSet.Balance(PublicKeyHash(other.public))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != BlockReward {
+		t.Fatalf("balance is %d, want %d", balance, BlockReward)
+	}
+}
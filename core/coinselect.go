@@ -0,0 +1,263 @@
+package core
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Outpoint points to a single output of a transaction
+type Outpoint struct {
+	TxID  []byte
+	Index int
+}
+
+func (o Outpoint) String() string {
+	return fmt.Sprintf("%x:%d", o.TxID, o.Index)
+}
+
+// ParseOutpoint reads an outpoint written as txid:index
+func ParseOutpoint(s string) (Outpoint, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return Outpoint{}, fmt.Errorf("outpoint %q is not in txid:index form", s)
+	}
+	txID, err := hex.DecodeString(parts[0])
+	if err != nil {
+		return Outpoint{}, fmt.Errorf("outpoint %q has an invalid txid: %s", s, err)
+	}
+	index, err := strconv.Atoi(parts[1])
+	if err != nil || index < 0 {
+		return Outpoint{}, fmt.Errorf("outpoint %q has an invalid index", s)
+	}
+	return Outpoint{txID, index}, nil
+}
+
+// ParseOutpoints reads a comma separated list of outpoints, an empty string
+// gives no outpoints
+func ParseOutpoints(s string) ([]Outpoint, error) {
+	var outpoints []Outpoint
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		outpoint, err := ParseOutpoint(part)
+		if err != nil {
+			return nil, err
+		}
+		outpoints = append(outpoints, outpoint)
+	}
+	return outpoints, nil
+}
+
+// SpendableOutput is an unspent output together with where to find it
+type SpendableOutput struct {
+	Outpoint
+	Output TxOutput
+}
+
+func sumOutputs(outputs []SpendableOutput) int {
+	total := 0
+	for _, out := range outputs {
+		total += out.Output.Amount
+	}
+	return total
+}
+
+// CoinSelector picks which unspent outputs fund a payment of amount. The
+// returned outputs must add up to at least amount.
+type CoinSelector interface {
+	Select(candidates []SpendableOutput, amount int) ([]SpendableOutput, error)
+}
+
+// LargestFirst spends the biggest outputs first, which keeps the number of
+// inputs as small as possible
+type LargestFirst struct{}
+
+func (LargestFirst) Select(candidates []SpendableOutput, amount int) ([]SpendableOutput, error) {
+	sorted := append([]SpendableOutput{}, candidates...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Output.Amount > sorted[j].Output.Amount
+	})
+
+	var selected []SpendableOutput
+	accumulated := 0
+	for _, out := range sorted {
+		if accumulated >= amount {
+			break
+		}
+		selected = append(selected, out)
+		accumulated += out.Output.Amount
+	}
+
+	if accumulated < amount {
+		return nil, ErrInsufficientFunds
+	}
+	return selected, nil
+}
+
+// BranchAndBound searches for a set of outputs that pays amount exactly, or
+// overshoots it by at most Tolerance, so that no change output is needed.
+// It fails when there is no such set within MaxTries steps.
+type BranchAndBound struct {
+	Tolerance int
+	MaxTries  int
+}
+
+const bnbMaxTries = 100000
+
+func (b BranchAndBound) Select(candidates []SpendableOutput, amount int) ([]SpendableOutput, error) {
+	sorted := append([]SpendableOutput{}, candidates...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Output.Amount > sorted[j].Output.Amount
+	})
+
+	if sumOutputs(sorted) < amount {
+		return nil, ErrInsufficientFunds
+	}
+
+	maxTries := b.MaxTries
+	if maxTries <= 0 {
+		maxTries = bnbMaxTries
+	}
+
+	// remaining[i] is the value still available from sorted[i:]
+	remaining := make([]int, len(sorted)+1)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + sorted[i].Output.Amount
+	}
+
+	var best []int
+	bestWaste := -1
+	var picked []int
+	tries := 0
+
+	var search func(depth, value int)
+	search = func(depth, value int) {
+		tries++
+		if tries > maxTries || bestWaste == 0 {
+			return
+		}
+		if value > amount+b.Tolerance || value+remaining[depth] < amount {
+			return
+		}
+		if value >= amount {
+			if waste := value - amount; bestWaste < 0 || waste < bestWaste {
+				bestWaste = waste
+				best = append([]int{}, picked...)
+			}
+			return
+		}
+		if depth == len(sorted) {
+			return
+		}
+
+		picked = append(picked, depth)
+		search(depth+1, value+sorted[depth].Output.Amount)
+		picked = picked[:len(picked)-1]
+
+		// skipping an output equal to the one just tried explores the same sums
+		next := depth + 1
+		for next < len(sorted) && sorted[next].Output.Amount == sorted[depth].Output.Amount {
+			next++
+		}
+		search(next, value)
+	}
+	search(0, 0)
+
+	if bestWaste < 0 {
+		return nil, errors.New("no exact match found for the amount")
+	}
+
+	var selected []SpendableOutput
+	for _, i := range best {
+		selected = append(selected, sorted[i])
+	}
+	return selected, nil
+}
+
+// RandomImprove picks random outputs until the amount is covered, then keeps
+// adding random outputs while that brings the change closer to the amount
+// itself. Change of a similar size to the payment hides which output is the
+// payment and avoids creating dust.
+type RandomImprove struct {
+	Rand *rand.Rand
+}
+
+func (r RandomImprove) Select(candidates []SpendableOutput, amount int) ([]SpendableOutput, error) {
+	rnd := r.Rand
+	if rnd == nil {
+		rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+
+	shuffled := append([]SpendableOutput{}, candidates...)
+	rnd.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+
+	var selected []SpendableOutput
+	accumulated := 0
+	next := 0
+	for ; next < len(shuffled) && accumulated < amount; next++ {
+		selected = append(selected, shuffled[next])
+		accumulated += shuffled[next].Output.Amount
+	}
+	if accumulated < amount {
+		return nil, ErrInsufficientFunds
+	}
+
+	ideal := 2 * amount
+	limit := 3 * amount
+	distance := func(v int) int {
+		if v > ideal {
+			return v - ideal
+		}
+		return ideal - v
+	}
+	for ; next < len(shuffled); next++ {
+		candidate := accumulated + shuffled[next].Output.Amount
+		if candidate > limit || distance(candidate) >= distance(accumulated) {
+			continue
+		}
+		selected = append(selected, shuffled[next])
+		accumulated = candidate
+	}
+
+	return selected, nil
+}
+
+// AutoSelect tries to avoid change with branch and bound first and falls back
+// to random improve
+type AutoSelect struct{}
+
+func (AutoSelect) Select(candidates []SpendableOutput, amount int) ([]SpendableOutput, error) {
+	if selected, err := (BranchAndBound{}).Select(candidates, amount); err == nil {
+		return selected, nil
+	}
+	return RandomImprove{}.Select(candidates, amount)
+}
+
+var coinSelectors = map[string]CoinSelector{
+	"auto":    AutoSelect{},
+	"largest": LargestFirst{},
+	"bnb":     BranchAndBound{},
+	"random":  RandomImprove{},
+}
+
+// GetCoinSelector looks a strategy up by name, the empty name is "auto"
+func GetCoinSelector(name string) (CoinSelector, error) {
+	if name == "" {
+		name = "auto"
+	}
+	selector, ok := coinSelectors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown coin selection strategy %q", name)
+	}
+	return selector, nil
+}
@@ -0,0 +1,143 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// utxos makes one spendable output per amount, each of its own transaction
+func utxos(amounts ...int) []SpendableOutput {
+	var outputs []SpendableOutput
+	for i, amount := range amounts {
+		outputs = append(outputs, SpendableOutput{
+			Outpoint: Outpoint{TxID: []byte{byte(i)}, Index: 0},
+			Output:   TxOutput{Amount: amount},
+		})
+	}
+	return outputs
+}
+
+// amounts lists the amounts of outputs from the biggest down
+func amounts(outputs []SpendableOutput) []int {
+	var list []int
+	for _, out := range outputs {
+		list = append(list, out.Output.Amount)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(list)))
+	return list
+}
+
+func TestCoinSelectors(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector CoinSelector
+		utxos    []SpendableOutput
+		amount   int
+		inputs   []int
+		change   int
+		err      error
+	}{
+		{"largest first takes the biggest", LargestFirst{}, utxos(5, 1, 3, 8), 9, []int{8, 5}, 4, nil},
+		{"largest first exact", LargestFirst{}, utxos(5, 1, 3, 8), 8, []int{8}, 0, nil},
+		{"largest first short", LargestFirst{}, utxos(5, 1, 3, 8), 20, nil, 0, ErrInsufficientFunds},
+
+		{"branch and bound exact", BranchAndBound{}, utxos(5, 1, 3, 8), 9, []int{8, 1}, 0, nil},
+		{"branch and bound small exact", BranchAndBound{}, utxos(5, 1, 3, 8), 4, []int{3, 1}, 0, nil},
+		{"branch and bound within tolerance", BranchAndBound{Tolerance: 1}, utxos(5, 1, 3, 8), 7, []int{8}, 1, nil},
+		{"branch and bound short", BranchAndBound{}, utxos(5, 1, 3, 8), 30, nil, 0, ErrInsufficientFunds},
+
+		{"random improve single output", RandomImprove{}, utxos(10), 3, []int{10}, 7, nil},
+		{"random improve aims at twice the amount", RandomImprove{}, utxos(4, 4, 4, 4), 4, []int{4, 4}, 4, nil},
+		{"random improve short", RandomImprove{}, utxos(1, 1), 5, nil, 0, ErrInsufficientFunds},
+
+		{"auto avoids change", AutoSelect{}, utxos(5, 1, 3, 8), 9, []int{8, 1}, 0, nil},
+		{"auto falls back to random improve", AutoSelect{}, utxos(4, 4, 4, 4), 6, []int{4, 4, 4}, 6, nil},
+		{"auto short", AutoSelect{}, utxos(1, 1), 5, nil, 0, ErrInsufficientFunds},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if r, ok := test.selector.(RandomImprove); ok {
+				r.Rand = rand.New(rand.NewSource(1))
+				test.selector = r
+			}
+
+			selected, err := test.selector.Select(test.utxos, test.amount)
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("got error %v, want %v", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := amounts(selected); !reflect.DeepEqual(got, test.inputs) {
+				t.Errorf("got inputs %v, want %v", got, test.inputs)
+			}
+			if change := sumOutputs(selected) - test.amount; change != test.change {
+				t.Errorf("got change %d, want %d", change, test.change)
+			}
+		})
+	}
+}
+
+func TestBranchAndBoundWithoutExactMatch(t *testing.T) {
+	_, err := BranchAndBound{}.Select(utxos(5, 1, 3, 8), 7)
+	if err == nil || errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("got error %v, want no exact match", err)
+	}
+}
+
+func TestRandomImproveCoversAmount(t *testing.T) {
+	candidates := utxos(1, 2, 3, 5, 8, 13, 21, 34)
+	for seed := int64(0); seed < 50; seed++ {
+		selected, err := RandomImprove{Rand: rand.New(rand.NewSource(seed))}.Select(candidates, 20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total := sumOutputs(selected); total < 20 {
+			t.Fatalf("seed %d: inputs of %d do not cover 20", seed, total)
+		}
+	}
+}
+
+func TestFindSpendableOutputsLeavesOutPendingSpends(t *testing.T) {
+	useRegtest(t)
+	key := newTestKey(t)
+	chain := newTestChain(t, key)
+
+	genesis, err := chain.GetBlock(chain.LatestHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := chain.MineBlock([]*Transaction{coinbase(t, key.address)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending := Outpoint{TxID: genesis.Transactions[0].Id, Index: 0}
+	spent := map[string]bool{pending.String(): true}
+	UTXOSet := UTXOSet{Blockchain: chain}
+	pubKeyHashes := [][]byte{PublicKeyHash(key.public)}
+
+	total, selected, err := UTXOSet.FindSpendableOutputs(pubKeyHashes, BlockReward, LargestFirst{}, nil, spent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != BlockReward || len(selected) != 1 || !bytes.Equal(selected[0].TxID, block.Transactions[0].Id) {
+		t.Fatalf("selected %v, want the output of block %x", selected, block.Hash)
+	}
+
+	_, _, err = UTXOSet.FindSpendableOutputs(pubKeyHashes, 2*BlockReward, LargestFirst{}, nil, spent)
+	if !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("got %v, want not enough funds", err)
+	}
+	if _, _, err := UTXOSet.FindSpendableOutputs(pubKeyHashes, BlockReward, nil, []Outpoint{pending}, spent); err == nil {
+		t.Fatal("a pending spend was pinned")
+	}
+}
@@ -0,0 +1,5 @@
+// Package core holds the chain itself: blocks and their proof of work,
+// transactions, the UTXO set and the per-address history index kept next to
+// it in the node database, addresses, coin selection, raw transactions and
+// the parameters of each network.
+package core
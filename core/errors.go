@@ -0,0 +1,19 @@
+package core
+
+import "errors"
+
+// Errors of the core API. Functions often wrap them with details, callers
+// test for them with errors.Is. The REST API maps them to status codes.
+var (
+	ErrInsufficientFunds = errors.New("not enough funds")
+	ErrInvalidAddress    = errors.New("invalid address")
+	ErrInvalidAmount     = errors.New("invalid amount")
+	ErrBlockNotFound     = errors.New("block is not found")
+	ErrTxNotFound        = errors.New("transaction does not exist")
+	ErrInvalidTx         = errors.New("invalid transaction")
+	ErrInvalidBlock      = errors.New("invalid block")
+	ErrMissingKey        = errors.New("no private key for input")
+	ErrChainExists       = errors.New("blockchain already exists")
+	ErrChainNotFound     = errors.New("blockchain does not exist, please init your blockchain first")
+	ErrMalformedMessage  = errors.New("malformed message")
+)
@@ -0,0 +1,322 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+
+	"github.com/dgraph-io/badger"
+)
+
+// BlockHeader is a block without its transactions. The proof of work covers
+// the merkle root of the transactions, so a header can be checked before the
+// block it stands for is downloaded, and the block checked against it after.
+type BlockHeader struct {
+	Timestamp    int64
+	Hash         []byte
+	PreviousHash []byte
+	MerkleRoot   []byte
+	Nonce        int
+	Height       int
+}
+
+func (block *Block) Header() BlockHeader {
+	return BlockHeader{
+		Timestamp:    block.Timestamp,
+		Hash:         block.Hash,
+		PreviousHash: block.PreviousHash,
+		MerkleRoot:   block.HashTransactions(),
+		Nonce:        block.Nonce,
+		Height:       block.Height,
+	}
+}
+
+// Validate checks the proof of work of the header, errors wrap
+// ErrInvalidBlock
+func (header *BlockHeader) Validate() error {
+	hash := sha256.Sum256(powData(header.PreviousHash, header.MerkleRoot, header.Nonce))
+	if !bytes.Equal(hash[:], header.Hash) {
+		return fmt.Errorf("%w: header hash of %x does not match", ErrInvalidBlock, header.Hash)
+	}
+
+	target := big.NewInt(1)
+	target.Lsh(target, uint(256-activeNet.Difficulty))
+	if new(big.Int).SetBytes(hash[:]).Cmp(target) != -1 {
+		return fmt.Errorf("%w: %x is above the target", ErrInvalidBlock, header.Hash)
+	}
+	return nil
+}
+
+// Block puts the header and txs together, Matches tells whether they belong
+// together
+func (header *BlockHeader) Block(txs []*Transaction) *Block {
+	return &Block{
+		Timestamp:    header.Timestamp,
+		Hash:         header.Hash,
+		Transactions: txs,
+		PreviousHash: header.PreviousHash,
+		Nonce:        header.Nonce,
+		Height:       header.Height,
+	}
+}
+
+// Matches tells whether block is the one header stands for
+func (header *BlockHeader) Matches(block *Block) bool {
+	return bytes.Equal(block.Hash, header.Hash) &&
+		bytes.Equal(block.PreviousHash, header.PreviousHash) &&
+		block.Height == header.Height &&
+		bytes.Equal(block.HashTransactions(), header.MerkleRoot)
+}
+
+func (chain *BlockChain) HasBlock(hash []byte) (bool, error) {
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(hash)
+		return err
+	})
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// BlockLocator lists hashes of the chain from the newest block back to the
+// genesis block, the ten newest one by one and then with a step that doubles,
+// so a peer finds the newest block both sides have in a few hashes
+func (chain *BlockChain) BlockLocator() ([][]byte, error) {
+	var locator [][]byte
+	step := 1
+	next := 0
+
+	iter := chain.Iterator()
+	for i := 0; ; i++ {
+		block, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		genesis := len(block.PreviousHash) == 0
+		if i == next || genesis {
+			locator = append(locator, block.Hash)
+			if len(locator) >= 10 {
+				step *= 2
+			}
+			next += step
+		}
+		if genesis {
+			return locator, nil
+		}
+	}
+}
+
+// HeadersAfter returns up to max headers of the chain that follow the newest
+// block of locator it has, oldest first. Without any block in common it
+// starts from the genesis block.
+func (chain *BlockChain) HeadersAfter(locator [][]byte, max int) ([]BlockHeader, error) {
+	known := make(map[string]bool, len(locator))
+	for _, hash := range locator {
+		known[string(hash)] = true
+	}
+
+	var headers []BlockHeader
+	iter := chain.Iterator()
+	for {
+		block, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		if known[string(block.Hash)] {
+			break
+		}
+		headers = append(headers, block.Header())
+		if len(block.PreviousHash) == 0 {
+			break
+		}
+	}
+
+	for i, j := 0, len(headers)-1; i < j; i, j = i+1, j-1 {
+		headers[i], headers[j] = headers[j], headers[i]
+	}
+	if len(headers) > max {
+		headers = headers[:max]
+	}
+	return headers, nil
+}
+
+// ConnectBlock adds a block whose header was checked to the chain. A block
+// that extends the newest block has its transactions checked and is added
+// together with the changes it makes to the UTXO set. A block that makes
+// another branch the longest one has the transactions of every block of that
+// branch checked first, then rebuilds the UTXO set. Blocks the chain has are
+// left alone. The caller holds the chain for writing.
+func (chain *BlockChain) ConnectBlock(block *Block) error {
+	if known, err := chain.HasBlock(block.Hash); err != nil || known {
+		return err
+	}
+
+	UTXOSet := UTXOSet{Blockchain: chain}
+	if bytes.Equal(block.PreviousHash, chain.LatestHash) {
+		if err := chain.checkTransactions(block); err != nil {
+			return err
+		}
+		return UTXOSet.connect(block)
+	}
+
+	bestHeight, err := chain.GetBestHeight()
+	if err != nil {
+		return err
+	}
+	if block.Height > bestHeight {
+		if err := chain.checkBranch(block); err != nil {
+			return err
+		}
+	}
+
+	latestHash := chain.LatestHash
+	if err := chain.AddBlock(block); err != nil {
+		return err
+	}
+	if bytes.Equal(latestHash, chain.LatestHash) {
+		return nil
+	}
+	return UTXOSet.Reindex()
+}
+
+// checkTransactions checks the transactions of a block that extends the
+// newest block against the UTXO set
+func (chain *BlockChain) checkTransactions(block *Block) error {
+	UTXOSet := UTXOSet{Blockchain: chain}
+	return checkBlockTransactions(block, chain.VerifyTransaction, UTXOSet.CheckInputs)
+}
+
+// checkBlockTransactions checks the transactions of a block: their signatures
+// with verify, that they spend unspent outputs once and cover their outputs
+// with checkInputs, and that the block has at most one coinbase, paying no
+// more than BlockReward and the fees. Errors wrap ErrInvalidTx or
+// ErrInvalidBlock.
+func checkBlockTransactions(block *Block, verify func(*Transaction) error, checkInputs func(*Transaction, map[string]bool) (int, error)) error {
+	spent := make(map[string]bool)
+	fees := 0
+	var coinbase *Transaction
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			if coinbase != nil {
+				return fmt.Errorf("%w: %x has two coinbase transactions", ErrInvalidBlock, block.Hash)
+			}
+			coinbase = tx
+			continue
+		}
+		if err := verify(tx); err != nil {
+			return err
+		}
+		fee, err := checkInputs(tx, spent)
+		if err != nil {
+			return err
+		}
+		fees += fee
+	}
+
+	if coinbase == nil {
+		return nil
+	}
+	reward := 0
+	for _, out := range coinbase.TxOutputs {
+		if out.Amount < 0 {
+			return fmt.Errorf("%w: coinbase %x pays a negative amount", ErrInvalidBlock, coinbase.Id)
+		}
+		reward += out.Amount
+	}
+	if reward > BlockReward+fees {
+		return fmt.Errorf("%w: coinbase %x pays %d, more than %d", ErrInvalidBlock, coinbase.Id, reward, BlockReward+fees)
+	}
+	return nil
+}
+
+// checkBranch checks the transactions of the blocks that block would add to
+// the chain when it makes their branch the longest one. The UTXO set is
+// replayed in memory from the genesis block along the branch, and every
+// block above the point where the branch leaves the current one is checked
+// against it.
+func (chain *BlockChain) checkBranch(block *Block) error {
+	current := make(map[string]bool)
+	iter := chain.Iterator()
+	for {
+		b, err := iter.Next()
+		if err != nil {
+			return err
+		}
+		current[string(b.Hash)] = true
+		if len(b.PreviousHash) == 0 {
+			break
+		}
+	}
+
+	branch := []*Block{block}
+	for b := block; len(b.PreviousHash) > 0; {
+		parent, err := chain.GetBlock(b.PreviousHash)
+		if err != nil {
+			return err
+		}
+		b = &parent
+		branch = append(branch, b)
+	}
+
+	replay := newBranchReplay()
+	for i := len(branch) - 1; i >= 0; i-- {
+		b := branch[i]
+		if !current[string(b.Hash)] {
+			if err := checkBlockTransactions(b, replay.verify, replay.checkInputs); err != nil {
+				return fmt.Errorf("block %x of the branch: %w", b.Hash, err)
+			}
+		}
+		replay.apply(b)
+	}
+	return nil
+}
+
+// branchReplay is the UTXO set of a branch kept in memory, with the
+// transactions whose outputs it holds
+type branchReplay struct {
+	txs     map[string]*Transaction
+	unspent map[string]TxOutput
+}
+
+func newBranchReplay() *branchReplay {
+	return &branchReplay{make(map[string]*Transaction), make(map[string]TxOutput)}
+}
+
+func (r *branchReplay) verify(tx *Transaction) error {
+	prevTXs := make(map[string]Transaction)
+	for _, in := range tx.TxInputs {
+		prevTX, ok := r.txs[hex.EncodeToString(in.Id)]
+		if !ok {
+			return fmt.Errorf("%w: %x spends unknown transaction %x", ErrInvalidTx, tx.Id, in.Id)
+		}
+		prevTXs[hex.EncodeToString(in.Id)] = *prevTX
+	}
+	if !tx.Verify(prevTXs) {
+		return fmt.Errorf("%w: %x has an invalid signature", ErrInvalidTx, tx.Id)
+	}
+	return nil
+}
+
+func (r *branchReplay) checkInputs(tx *Transaction, spent map[string]bool) (int, error) {
+	return checkInputs(tx, spent, func(in TxInput) (TxOutput, bool, error) {
+		out, ok := r.unspent[Outpoint{in.Id, in.OutIndex}.String()]
+		return out, ok, nil
+	})
+}
+
+func (r *branchReplay) apply(block *Block) {
+	for _, tx := range block.Transactions {
+		if !tx.IsCoinbase() {
+			for _, in := range tx.TxInputs {
+				delete(r.unspent, Outpoint{in.Id, in.OutIndex}.String())
+			}
+		}
+		for i, out := range tx.TxOutputs {
+			r.unspent[Outpoint{tx.Id, i}.String()] = out
+		}
+		r.txs[hex.EncodeToString(tx.Id)] = tx
+	}
+}
@@ -0,0 +1,151 @@
+package core
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"testing"
+)
+
+// testKey is a key with its address. Verify splits the public key in halves,
+// so only keys whose coordinates have their full length are used.
+type testKey struct {
+	private ecdsa.PrivateKey
+	public  []byte
+	address string
+}
+
+func newTestKey(t *testing.T) testKey {
+	for {
+		private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		public := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+		if len(public) == 64 {
+			return testKey{*private, public, PubKeyHashToAddress(PublicKeyHash(public))}
+		}
+	}
+}
+
+// useRegtest runs the test on the regtest network with its files in a
+// temporary directory
+func useRegtest(t *testing.T) {
+	if err := SelectNetwork("regtest"); err != nil {
+		t.Fatal(err)
+	}
+	SetDataDir(t.TempDir())
+	if err := os.MkdirAll(NetPath("./db", ""), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestChain makes a chain whose genesis block pays key, call useRegtest
+// first
+func newTestChain(t *testing.T, key testKey) *BlockChain {
+	chain, err := InitMyChain(key.address, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { chain.Database.Close() })
+
+	UTXOSet := UTXOSet{Blockchain: chain}
+	if err := UTXOSet.Reindex(); err != nil {
+		t.Fatal(err)
+	}
+	return chain
+}
+
+// spend pays amount of output index of prev, which key can spend, to address
+// and signs it
+func spend(t *testing.T, key testKey, prev *Transaction, index, amount int, address string) *Transaction {
+	out, err := NewTxOut(amount, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := &Transaction{
+		TxInputs:  []TxInput{{prev.Id, index, nil, key.public}},
+		TxOutputs: []TxOutput{*out},
+	}
+	tx.Id = tx.Hash()
+
+	privKeys := map[string]ecdsa.PrivateKey{hex.EncodeToString(key.public): key.private}
+	prevTXs := map[string]Transaction{hex.EncodeToString(prev.Id): *prev}
+	if err := tx.SignWithKeys(privKeys, prevTXs); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func coinbase(t *testing.T, address string) *Transaction {
+	tx, err := CreateCoinbaseTx(address, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestConnectBlockChecksTheBranchItSwitchesTo(t *testing.T) {
+	useRegtest(t)
+	key := newTestKey(t)
+	other := newTestKey(t)
+	chain := newTestChain(t, key)
+
+	genesis, err := chain.GetBlock(chain.LatestHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	funds := genesis.Transactions[0]
+
+	main := CreateBlock([]*Transaction{coinbase(t, key.address)}, genesis.Hash, 1)
+	if err := chain.ConnectBlock(main); err != nil {
+		t.Fatal(err)
+	}
+
+	// the branch spends the genesis output in its first block and again in
+	// its second one, which makes it the longest
+	first := CreateBlock([]*Transaction{spend(t, key, funds, 0, BlockReward, other.address)}, genesis.Hash, 1)
+	if err := chain.ConnectBlock(first); err != nil {
+		t.Fatal(err)
+	}
+	doubleSpend := CreateBlock([]*Transaction{spend(t, key, funds, 0, BlockReward, key.address)}, first.Hash, 2)
+	err = chain.ConnectBlock(doubleSpend)
+	if !errors.Is(err, ErrInvalidTx) {
+		t.Fatalf("got %v, want an invalid transaction", err)
+	}
+	if !bytes.Equal(chain.LatestHash, main.Hash) {
+		t.Fatalf("tip moved to %x", chain.LatestHash)
+	}
+	if known, err := chain.HasBlock(doubleSpend.Hash); err != nil || known {
+		t.Fatalf("refused block is stored: %v", err)
+	}
+
+	// too big a coinbase is refused the same way
+	greedy := coinbase(t, key.address)
+	greedy.TxOutputs[0].Amount = BlockReward + 1
+	greedy.Id = greedy.Hash()
+	err = chain.ConnectBlock(CreateBlock([]*Transaction{greedy}, first.Hash, 2))
+	if !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("got %v, want an invalid block", err)
+	}
+
+	valid := CreateBlock([]*Transaction{coinbase(t, other.address)}, first.Hash, 2)
+	if err := chain.ConnectBlock(valid); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(chain.LatestHash, valid.Hash) {
+		t.Fatalf("tip is %x, want the branch", chain.LatestHash)
+	}
+	UTXOSet := UTXOSet{Blockchain: chain}
+	balance, err := UTXOSet.Balance(PublicKeyHash(other.public))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 2*BlockReward {
+		t.Fatalf("balance of the branch is %d, want %d", balance, 2*BlockReward)
+	}
+}
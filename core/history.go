@@ -0,0 +1,300 @@
+package core
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/dgraph-io/badger"
+)
+
+var historyPrefix = []byte("hist-")
+
+// history index keeps one entry per (public key hash, transaction) so a wallet
+// can list its own incoming/outgoing transactions without walking the chain
+type TxHistory struct {
+	Blockchain *BlockChain
+}
+
+type TxHistoryEntry struct {
+	TxID string `json:"txid"`
+	// PubKeyHash and CounterpartyHashes are what the index keeps. Address and
+	// Counterparties are in Base58 when the history is read, a wallet rewrites
+	// the ones it knows in their own format.
+	PubKeyHash         []byte   `json:"-"`
+	CounterpartyHashes [][]byte `json:"-"`
+
+	Address        string   `json:"address"`
+	Amount         int      `json:"amount"`
+	Counterparties []string `json:"counterparties"`
+	Coinbase       bool     `json:"coinbase"`
+	Height         int      `json:"height"`
+	Confirmations  int      `json:"confirmations"`
+	Timestamp      int64    `json:"timestamp"`
+
+	// labels come from the wallet when the history is listed, they are not
+	// part of the index
+	Label              string            `json:"label,omitempty"`
+	CounterpartyLabels map[string]string `json:"counterparty_labels,omitempty"`
+}
+
+func (e TxHistoryEntry) Serialize() []byte {
+	var buffer bytes.Buffer
+
+	encode := gob.NewEncoder(&buffer)
+	err := encode.Encode(e)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return buffer.Bytes()
+}
+
+func DeserializeHistoryEntry(data []byte) (TxHistoryEntry, error) {
+	var entry TxHistoryEntry
+
+	decode := gob.NewDecoder(bytes.NewReader(data))
+	err := decode.Decode(&entry)
+
+	return entry, err
+}
+
+// key layout: prefix | pubKeyHash | height (big endian) | txid, so iterating a
+// pubKeyHash prefix yields its transactions ordered by height
+func historyKey(pubKeyHash []byte, height int, txID []byte) []byte {
+	key := append([]byte{}, historyPrefix...)
+	key = append(key, pubKeyHash...)
+	key = append(key, ToHex(int64(height))...)
+	return append(key, txID...)
+}
+
+// historyEntries computes the balance change of every public key hash touched
+// by the transactions of a block. prevOut resolves the output spent by an input.
+func historyEntries(block *Block, prevOut func(in TxInput) (TxOutput, error)) (map[string][]TxHistoryEntry, error) {
+	entries := make(map[string][]TxHistoryEntry)
+
+	for _, tx := range block.Transactions {
+		deltas := make(map[string]int)
+		senders := make(map[string]bool)
+		var order []string
+
+		touch := func(pubKeyHash []byte) string {
+			key := hex.EncodeToString(pubKeyHash)
+			if _, ok := deltas[key]; !ok {
+				deltas[key] = 0
+				order = append(order, key)
+			}
+			return key
+		}
+
+		if !tx.IsCoinbase() {
+			for _, in := range tx.TxInputs {
+				out, err := prevOut(in)
+				if err != nil {
+					return nil, err
+				}
+				key := touch(out.PublicKey)
+				deltas[key] -= out.Amount
+				senders[key] = true
+			}
+		}
+
+		for _, out := range tx.TxOutputs {
+			key := touch(out.PublicKey)
+			deltas[key] += out.Amount
+		}
+
+		for _, key := range order {
+			var counterparties [][]byte
+			for _, other := range order {
+				if other == key || senders[other] == senders[key] {
+					continue
+				}
+				pubKeyHash, _ := hex.DecodeString(other)
+				counterparties = append(counterparties, pubKeyHash)
+			}
+
+			pubKeyHash, _ := hex.DecodeString(key)
+			entries[key] = append(entries[key], TxHistoryEntry{
+				TxID:               hex.EncodeToString(tx.Id),
+				PubKeyHash:         pubKeyHash,
+				CounterpartyHashes: counterparties,
+				Amount:             deltas[key],
+				Coinbase:           tx.IsCoinbase(),
+				Height:             block.Height,
+				Timestamp:          block.Timestamp,
+			})
+		}
+	}
+
+	return entries, nil
+}
+
+// fillAddresses writes the addresses of an entry read from the index in
+// Base58. Entries indexed before the index kept public key hashes only have
+// the addresses, their hashes are decoded from them.
+func (e *TxHistoryEntry) fillAddresses() {
+	if e.PubKeyHash == nil {
+		e.PubKeyHash, _ = DecodeAddress(e.Address)
+		for _, counterparty := range e.Counterparties {
+			pubKeyHash, _ := DecodeAddress(counterparty)
+			e.CounterpartyHashes = append(e.CounterpartyHashes, pubKeyHash)
+		}
+		return
+	}
+
+	e.Address = PubKeyHashToAddress(e.PubKeyHash)
+	e.Counterparties = nil
+	for _, pubKeyHash := range e.CounterpartyHashes {
+		e.Counterparties = append(e.Counterparties, PubKeyHashToAddress(pubKeyHash))
+	}
+}
+
+func (h TxHistory) writeEntries(txn *badger.Txn, entries map[string][]TxHistoryEntry) error {
+	for key, list := range entries {
+		pubKeyHash, err := hex.DecodeString(key)
+		if err != nil {
+			return err
+		}
+		for _, entry := range list {
+			txID, err := hex.DecodeString(entry.TxID)
+			if err != nil {
+				return err
+			}
+			if err := txn.Set(historyKey(pubKeyHash, entry.Height, txID), entry.Serialize()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (h TxHistory) Reindex() error {
+	db := h.Blockchain.Database
+
+	u := UTXOSet{h.Blockchain}
+	if err := u.DeleteByPrefix(historyPrefix); err != nil {
+		return err
+	}
+
+	var blocks []*Block
+	iter := h.Blockchain.Iterator()
+	for {
+		block, err := iter.Next()
+		if err != nil {
+			return err
+		}
+		blocks = append(blocks, block)
+
+		if len(block.PreviousHash) == 0 {
+			break
+		}
+	}
+
+	// replay from genesis so every spent output is known before it is spent
+	outputs := make(map[string][]TxOutput)
+	prevOut := func(in TxInput) (TxOutput, error) {
+		outs := outputs[hex.EncodeToString(in.Id)]
+		if in.OutIndex < 0 || in.OutIndex >= len(outs) {
+			return TxOutput{}, fmt.Errorf("%w: previous output %x:%d", ErrTxNotFound, in.Id, in.OutIndex)
+		}
+		return outs[in.OutIndex], nil
+	}
+
+	for i := len(blocks) - 1; i >= 0; i-- {
+		block := blocks[i]
+		entries, err := historyEntries(block, prevOut)
+		if err != nil {
+			return err
+		}
+		for _, tx := range block.Transactions {
+			outputs[hex.EncodeToString(tx.Id)] = tx.TxOutputs
+		}
+
+		err = db.Update(func(txn *badger.Txn) error {
+			return h.writeEntries(txn, entries)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blockEntries computes the history entries of a block that extends the
+// newest block
+func (h TxHistory) blockEntries(block *Block) (map[string][]TxHistoryEntry, error) {
+	prevOut := func(in TxInput) (TxOutput, error) {
+		prevTX, err := h.Blockchain.FindTransaction(in.Id)
+		if err != nil {
+			return TxOutput{}, err
+		}
+		if in.OutIndex < 0 || in.OutIndex >= len(prevTX.TxOutputs) {
+			return TxOutput{}, fmt.Errorf("%w: previous output %x:%d", ErrTxNotFound, in.Id, in.OutIndex)
+		}
+		return prevTX.TxOutputs[in.OutIndex], nil
+	}
+	return historyEntries(block, prevOut)
+}
+
+// FindHistory returns the transactions touching the given public key hashes,
+// newest first
+func (h TxHistory) FindHistory(pubKeyHashes ...[]byte) ([]TxHistoryEntry, error) {
+	var history []TxHistoryEntry
+	bestHeight, err := h.Blockchain.GetBestHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	err = h.Blockchain.Database.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for _, pubKeyHash := range pubKeyHashes {
+			prefix := append(append([]byte{}, historyPrefix...), pubKeyHash...)
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				v, err := it.Item().Value()
+				if err != nil {
+					return err
+				}
+				entry, err := DeserializeHistoryEntry(v)
+				if err != nil {
+					return err
+				}
+				entry.fillAddresses()
+				entry.Confirmations = bestHeight - entry.Height + 1
+				history = append(history, entry)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].Height > history[j].Height
+	})
+
+	return history, nil
+}
+
+// PageHistory cuts a page out of a history list, skip and count are clamped
+func PageHistory(history []TxHistoryEntry, skip, count int) []TxHistoryEntry {
+	if skip < 0 {
+		skip = 0
+	}
+	if skip >= len(history) {
+		return []TxHistoryEntry{}
+	}
+	end := len(history)
+	if count > 0 && skip+count < end {
+		end = skip + count
+	}
+	return history[skip:end]
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// NetParams describes one network. Nodes of different networks use different
+// address prefixes, genesis blocks and magic bytes so that neither addresses
+// nor peers of one network are accepted by another.
+type NetParams struct {
+	Name           string
+	AddressVersion byte
+	Bech32HRP      string
+	GenesisData    string
+	DefaultPort    string
+	Magic          [4]byte
+	Difficulty     int
+	// DataDir keeps the databases and wallet files of a network apart, the
+	// main network uses the top level directories
+	DataDir string
+}
+
+var (
+	MainNetParams = NetParams{
+		Name:           "mainnet",
+		AddressVersion: 0x00,
+		Bech32HRP:      "bk",
+		GenesisData:    "Genesis data",
+		DefaultPort:    "3000",
+		Magic:          [4]byte{0xf9, 0xbe, 0xb4, 0xd9},
+		Difficulty:     12,
+		DataDir:        "",
+	}
+	TestNetParams = NetParams{
+		Name:           "testnet",
+		AddressVersion: 0x6f,
+		Bech32HRP:      "tbk",
+		GenesisData:    "Testnet genesis data",
+		DefaultPort:    "13000",
+		Magic:          [4]byte{0x0b, 0x11, 0x09, 0x07},
+		Difficulty:     8,
+		DataDir:        "testnet",
+	}
+	RegTestParams = NetParams{
+		Name:           "regtest",
+		AddressVersion: 0x3c,
+		Bech32HRP:      "rbk",
+		GenesisData:    "Regtest genesis data",
+		DefaultPort:    "23000",
+		Magic:          [4]byte{0xfa, 0xbf, 0xb5, 0xda},
+		Difficulty:     1,
+		DataDir:        "regtest",
+	}
+)
+
+var networks = map[string]*NetParams{
+	MainNetParams.Name: &MainNetParams,
+	TestNetParams.Name: &TestNetParams,
+	RegTestParams.Name: &RegTestParams,
+}
+
+// activeNet is the network this process runs on, set once at start up by
+// SelectNetwork
+var activeNet = &MainNetParams
+
+// dataDir holds the files of every node of this process, set once at start
+// up by SetDataDir
+var dataDir = "."
+
+// SelectNetwork switches the process to the named network
+func SelectNetwork(name string) error {
+	params, ok := networks[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("unknown network %q, use mainnet, testnet or regtest", name)
+	}
+
+	activeNet = params
+	return nil
+}
+
+// ActiveNet returns the parameters of the network picked by SelectNetwork
+func ActiveNet() *NetParams {
+	return activeNet
+}
+
+// SetDataDir moves the files of the nodes of this process to dir
+func SetDataDir(dir string) {
+	dataDir = dir
+}
+
+// NetPath places a file or directory inside the data directory of the
+// active network, dir is relative to the directory set with SetDataDir
+func NetPath(dir, name string) string {
+	return filepath.Join(dataDir, dir, activeNet.DataDir, name)
+}
@@ -1,24 +1,27 @@
-package main
+package core
 
 import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/binary"
-	"fmt"
 	"log"
 	"math"
 	"math/big"
 )
 
-const Difficulty = 12
-
 type ProofOfWork struct {
 	Block  *Block
 	Target *big.Int
 }
 
 func (pow *ProofOfWork) InitData(nonce int) []byte {
-	return bytes.Join([][]byte{pow.Block.PreviousHash, pow.Block.HashTransactions(), ToHex(int64(nonce)), ToHex(int64(Difficulty))}, []byte{})
+	return powData(pow.Block.PreviousHash, pow.Block.HashTransactions(), nonce)
+}
+
+// powData is what the proof of work hashes, the header of a block carries all
+// of it
+func powData(previousHash, merkleRoot []byte, nonce int) []byte {
+	return bytes.Join([][]byte{previousHash, merkleRoot, ToHex(int64(nonce)), ToHex(int64(activeNet.Difficulty))}, []byte{})
 }
 
 // this proof of work is from algorithm is from: https://www.youtube.com/watch?v=aE4eDTUAE70&list=PLpP5MQvVi4PGmNYGEsShrlvuE2B33xV1L&index=2
@@ -29,7 +32,6 @@ func (pow *ProofOfWork) Start() (int, []byte) {
 	for nonce < math.MaxInt64 {
 		data := pow.InitData(nonce)
 		hash = sha256.Sum256(data)
-		fmt.Printf("\r%x", hash)
 		// convert hash to decimal
 		hashDecimal.SetBytes(hash[:])
 
@@ -40,7 +42,6 @@ func (pow *ProofOfWork) Start() (int, []byte) {
 			nonce++
 		}
 	}
-	fmt.Println()
 	return nonce, hash[:]
 }
 
@@ -54,7 +55,7 @@ func (pow *ProofOfWork) Validate() bool {
 
 func StartProofOfWork(block *Block) *ProofOfWork {
 	target := big.NewInt(1)
-	target.Lsh(target, uint(256-Difficulty))
+	target.Lsh(target, uint(256-activeNet.Difficulty))
 	pow := &ProofOfWork{block, target}
 	return pow
 }
@@ -0,0 +1,121 @@
+package core
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"log"
+)
+
+// RawTx carries a transaction between an online node, that knows the chain,
+// and an offline node, that only has the wallet file. It embeds the previous
+// transactions spent by the inputs because signing covers their outputs.
+type RawTx struct {
+	Tx      Transaction
+	PrevTxs map[string]Transaction
+}
+
+func (raw RawTx) Serialize() []byte {
+	var encoded bytes.Buffer
+	enc := gob.NewEncoder(&encoded)
+	err := enc.Encode(raw)
+	if err != nil {
+		log.Panic(err)
+	}
+	return encoded.Bytes()
+}
+
+// Encode gives the hex form that is copied between nodes
+func (raw RawTx) Encode() string {
+	return hex.EncodeToString(raw.Serialize())
+}
+
+func DecodeRawTx(data string) (RawTx, error) {
+	var raw RawTx
+
+	decoded, err := hex.DecodeString(data)
+	if err != nil {
+		return raw, fmt.Errorf("%w: raw transaction is not hex: %s", ErrInvalidTx, err)
+	}
+
+	decoder := gob.NewDecoder(bytes.NewReader(decoded))
+	if err := decoder.Decode(&raw); err != nil {
+		return raw, fmt.Errorf("%w: raw transaction is malformed: %s", ErrInvalidTx, err)
+	}
+	if raw.PrevTxs == nil {
+		raw.PrevTxs = make(map[string]Transaction)
+	}
+
+	return raw, nil
+}
+
+// CreateRawTx builds the unsigned transaction paying recipients from address.
+// Change goes to change, or back to address when change is empty.
+func CreateRawTx(address string, recipients map[string]int, change string, UTXO *UTXOSet, opts TxOptions) (RawTx, error) {
+	if change == "" {
+		change = address
+	}
+
+	pubKeyHash, err := DecodeAddress(address)
+	if err != nil {
+		return RawTx{}, err
+	}
+	tx, _, err := BuildTx([][]byte{pubKeyHash}, recipients, change, UTXO, opts)
+	if err != nil {
+		return RawTx{}, err
+	}
+	prevTXs, err := UTXO.Blockchain.PreviousTransactions(&tx)
+	if err != nil {
+		return RawTx{}, err
+	}
+
+	return RawTx{tx, prevTXs}, nil
+}
+
+// IsSigned reports whether every input carries a signature
+func (raw RawTx) IsSigned() bool {
+	for _, in := range raw.Tx.TxInputs {
+		if len(in.Signature) == 0 {
+			return false
+		}
+	}
+	return len(raw.Tx.TxInputs) > 0
+}
+
+// InputAmount sums the outputs spent by the transaction
+func (raw RawTx) InputAmount() int {
+	total := 0
+	for _, in := range raw.Tx.TxInputs {
+		prevTX := raw.PrevTxs[hex.EncodeToString(in.Id)]
+		if in.OutIndex >= 0 && in.OutIndex < len(prevTX.TxOutputs) {
+			total += prevTX.TxOutputs[in.OutIndex].Amount
+		}
+	}
+	return total
+}
+
+// CheckPrevTxs makes sure the embedded previous transactions are the ones the
+// inputs point to, an id is the hash of the transaction before it was signed
+func (raw RawTx) CheckPrevTxs() error {
+	for _, in := range raw.Tx.TxInputs {
+		prevTX, ok := raw.PrevTxs[hex.EncodeToString(in.Id)]
+		if !ok {
+			return fmt.Errorf("%w: previous transaction %x is missing", ErrInvalidTx, in.Id)
+		}
+
+		unsigned := prevTX
+		unsigned.TxInputs = nil
+		for _, prevIn := range prevTX.TxInputs {
+			prevIn.Signature = nil
+			unsigned.TxInputs = append(unsigned.TxInputs, prevIn)
+		}
+		if !bytes.Equal(unsigned.Hash(), in.Id) {
+			return fmt.Errorf("%w: previous transaction %x does not match its id", ErrInvalidTx, in.Id)
+		}
+		if in.OutIndex < 0 || in.OutIndex >= len(prevTX.TxOutputs) {
+			return fmt.Errorf("%w: previous transaction %x has no output %d", ErrInvalidTx, in.Id, in.OutIndex)
+		}
+	}
+	return nil
+}
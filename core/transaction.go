@@ -1,4 +1,4 @@
-package main
+package core
 
 import (
 	"bytes"
@@ -9,11 +9,17 @@ import (
 	"encoding/gob"
 	"encoding/hex"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"math/big"
+	"sort"
 	"strings"
 )
 
+// BlockReward is what the coinbase of a block may pay on top of the fees of
+// the block
+const BlockReward = 20
+
 type Transaction struct {
 	Id        []byte
 	TxInputs  []TxInput
@@ -27,6 +33,23 @@ type TxOutput struct {
 
 type TxOutputs struct {
 	Outputs []TxOutput
+	// Indexes holds the position of each output inside its transaction, spent
+	// outputs are dropped from the UTXO set so the slice position shifts
+	Indexes []int
+}
+
+// TxOptions tunes how BuildTx funds a transaction
+type TxOptions struct {
+	// Selector picks the inputs, nil means AutoSelect
+	Selector CoinSelector
+	// Inputs are always spent, the selector only tops them up when needed
+	Inputs []Outpoint
+	// Spent are outputs pending transactions already spend, keyed by
+	// Outpoint.String. They are not selected.
+	Spent map[string]bool
+	// NewChange returns the address that receives the change, it is only
+	// called when there is change. nil sends change back to the sender.
+	NewChange func() (string, error)
 }
 
 type TxInput struct {
@@ -66,21 +89,36 @@ func (txtIn *TxInput) UsesKey(pubKey []byte) bool {
 	return bytes.Compare(lockhash, pubKey) == 0
 }
 
-func (txOut *TxOutput) Lock(address []byte) {
-	pubKey := Base58Decode(address)
-	pubKey = pubKey[1 : len(pubKey)-4]
-	txOut.PublicKey = pubKey
+func (txOut *TxOutput) Lock(address []byte) error {
+	pubKeyHash, err := DecodeAddress(string(address))
+	if err != nil {
+		return err
+	}
+	txOut.PublicKey = pubKeyHash
+	return nil
 }
 
 func (txOut *TxOutput) KeyLocked(pubKey []byte) bool {
 	return bytes.Compare(txOut.PublicKey, pubKey) == 0
 }
 
-func NewTxOut(val int, address string) *TxOutput {
+func NewTxOut(val int, address string) (*TxOutput, error) {
 	txo := TxOutput{val, nil}
-	txo.Lock([]byte(address))
+	if err := txo.Lock([]byte(address)); err != nil {
+		return nil, err
+	}
 
-	return &txo
+	return &txo, nil
+}
+
+// gob numbers types in the order a process first meets them and writes the
+// numbers out, so Serialize only gives the same bytes, and merkle roots and
+// ids the same hashes, in every process when transactions are the first
+// types encoded
+func init() {
+	if err := gob.NewEncoder(ioutil.Discard).Encode(Transaction{}); err != nil {
+		log.Panic(err)
+	}
 }
 
 func (tx Transaction) Serialize() []byte {
@@ -101,14 +139,29 @@ func (tx *Transaction) Hash() []byte {
 
 	return hash[:]
 }
-func (tx Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) {
+func (tx Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) error {
+	privKeys := make(map[string]ecdsa.PrivateKey)
+	for _, in := range tx.TxInputs {
+		privKeys[hex.EncodeToString(in.PublicKey)] = privKey
+	}
+
+	return tx.SignWithKeys(privKeys, prevTXs)
+}
+
+// SignWithKeys signs every input with the private key of its public key,
+// privKeys is keyed by the hex encoded public key
+func (tx Transaction) SignWithKeys(privKeys map[string]ecdsa.PrivateKey, prevTXs map[string]Transaction) error {
 	if tx.IsCoinbase() {
-		return
+		return nil
 	}
 
-	for _, in := range tx.TxInputs {
-		if prevTXs[hex.EncodeToString(in.Id)].Id == nil {
-			log.Panic("ERROR: Previous transaction is not correct")
+	for inId, in := range tx.TxInputs {
+		prevTX := prevTXs[hex.EncodeToString(in.Id)]
+		if prevTX.Id == nil || in.OutIndex < 0 || in.OutIndex >= len(prevTX.TxOutputs) {
+			return fmt.Errorf("%w: previous output %x:%d of input %d", ErrTxNotFound, in.Id, in.OutIndex, inId)
+		}
+		if _, ok := privKeys[hex.EncodeToString(in.PublicKey)]; !ok {
+			return fmt.Errorf("%w %d", ErrMissingKey, inId)
 		}
 	}
 
@@ -121,14 +174,17 @@ func (tx Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transact
 
 		dataToSign := fmt.Sprintf("%x\n", txCopy)
 
+		privKey := privKeys[hex.EncodeToString(tx.TxInputs[inId].PublicKey)]
 		r, s, err := ecdsa.Sign(rand.Reader, &privKey, []byte(dataToSign))
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
-		signature := append(r.Bytes(), s.Bytes()...)
+		// Verify splits the signature in halves, r and s keep their length
+		signature := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
 		tx.TxInputs[inId].Signature = signature
 		txCopy.TxInputs[inId].PublicKey = nil
 	}
+	return nil
 }
 
 func (tx *Transaction) TrimmedCopy() Transaction {
@@ -153,9 +209,15 @@ func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
 		return true
 	}
 
+	// a transaction from a peer may point anywhere, so it is checked before
+	// anything is indexed
 	for _, in := range tx.TxInputs {
-		if prevTXs[hex.EncodeToString(in.Id)].Id == nil {
-			log.Panic("ERROR: Previous transaction does not exist")
+		prevTX := prevTXs[hex.EncodeToString(in.Id)]
+		if prevTX.Id == nil || in.OutIndex < 0 || in.OutIndex >= len(prevTX.TxOutputs) {
+			return false
+		}
+		if len(in.Signature) == 0 || len(in.Signature)/2 > len(in.PublicKey) {
+			return false
 		}
 	}
 	txCopy := tx.TrimmedCopy()
@@ -208,61 +270,93 @@ func (tx Transaction) String() string {
 }
 
 // coinbase tx is tx that has no sender, usually the first tx of genesis block or reward tx
-func CreateCoinbaseTx(to, data string) *Transaction {
+func CreateCoinbaseTx(to, data string) (*Transaction, error) {
 	if data == "" {
 		randData := make([]byte, 24)
 		_, err := rand.Read(randData)
 		if err != nil {
-			log.Panic(err)
+			return nil, err
 		}
 		data = fmt.Sprintf("%x", randData)
 	}
 
 	txIn := TxInput{[]byte{}, -1, nil, []byte(data)}
-	txOut := NewTxOut(20, to)
+	txOut, err := NewTxOut(BlockReward, to)
+	if err != nil {
+		return nil, err
+	}
 
 	tx := Transaction{nil, []TxInput{txIn}, []TxOutput{*txOut}}
 	tx.Id = tx.Hash()
 
-	return &tx
+	return &tx, nil
 }
 
-func CreateTx(w *Wallet, to string, amount int, UTXO *UTXOSet) *Transaction {
+// BuildTx selects outputs of pubKeyHashes to pay the recipients and returns the
+// transaction without id, public keys and signatures, together with the
+// outputs its inputs spend
+func BuildTx(pubKeyHashes [][]byte, recipients map[string]int, change string, UTXO *UTXOSet, opts TxOptions) (Transaction, []SpendableOutput, error) {
 	var inputs []TxInput
 	var outputs []TxOutput
 
-	pubKey := PublicKeyHash(w.PublicKey)
-
-	acc, validOutputs := UTXO.FindSpendableOutputs(pubKey, amount)
-
-	if acc < amount {
-		log.Panic("Error: not enough funds")
+	if len(recipients) == 0 {
+		return Transaction{}, nil, fmt.Errorf("%w: no recipients", ErrInvalidAmount)
 	}
 
-	for txid, outs := range validOutputs {
-		txId, err := hex.DecodeString(txid)
-		if err != nil {
-			log.Panic(err)
+	var addresses []string
+	amount := 0
+	for to, value := range recipients {
+		if value <= 0 {
+			return Transaction{}, nil, fmt.Errorf("%w: amount for %s must be positive", ErrInvalidAmount, to)
 		}
-
-		for _, out := range outs {
-			inputs = append(inputs, TxInput{txId, out, nil, w.PublicKey})
+		if _, err := DecodeAddress(to); err != nil {
+			return Transaction{}, nil, err
 		}
+		addresses = append(addresses, to)
+		amount += value
 	}
+	sort.Strings(addresses)
 
-	from := fmt.Sprintf("%s", w.Address())
+	acc, validOutputs, err := UTXO.FindSpendableOutputs(pubKeyHashes, amount, opts.Selector, opts.Inputs, opts.Spent)
+	if err != nil {
+		return Transaction{}, nil, err
+	}
+
+	for _, out := range validOutputs {
+		inputs = append(inputs, TxInput{out.TxID, out.Index, nil, nil})
+	}
 
-	outputs = append(outputs, *NewTxOut(amount, to))
+	for _, to := range addresses {
+		out, err := NewTxOut(recipients[to], to)
+		if err != nil {
+			return Transaction{}, nil, err
+		}
+		outputs = append(outputs, *out)
+	}
 
 	if acc > amount {
-		outputs = append(outputs, *NewTxOut(acc-amount, from))
+		if opts.NewChange != nil {
+			var err error
+			if change, err = opts.NewChange(); err != nil {
+				return Transaction{}, nil, err
+			}
+		}
+		out, err := NewTxOut(acc-amount, change)
+		if err != nil {
+			return Transaction{}, nil, err
+		}
+		outputs = append(outputs, *out)
 	}
 
-	tx := Transaction{nil, inputs, outputs}
-	tx.Id = tx.Hash()
+	return Transaction{nil, inputs, outputs}, validOutputs, nil
+}
 
-	UTXO.Blockchain.SignTransaction(&tx, w.PrivateKey)
-	return &tx
+// Index returns the position inside its transaction of the i-th output
+func (outs TxOutputs) Index(i int) int {
+	if len(outs.Indexes) == len(outs.Outputs) {
+		return outs.Indexes[i]
+	}
+	return i
 }
 
 func (outs TxOutputs) Serialize() []byte {
@@ -277,25 +371,22 @@ func (outs TxOutputs) Serialize() []byte {
 	return buffer.Bytes()
 }
 
-func DeserializeOutputs(data []byte) TxOutputs {
+func DeserializeOutputs(data []byte) (TxOutputs, error) {
 	var outputs TxOutputs
 
 	decode := gob.NewDecoder(bytes.NewReader(data))
 	err := decode.Decode(&outputs)
-	if err != nil {
-		log.Panic(err)
-	}
 
-	return outputs
+	return outputs, err
 }
 
-func DeserializeTransaction(data []byte) Transaction {
+func DeserializeTransaction(data []byte) (Transaction, error) {
 	var transaction Transaction
 
 	decoder := gob.NewDecoder(bytes.NewReader(data))
 	err := decoder.Decode(&transaction)
 	if err != nil {
-		log.Panic(err)
+		return transaction, fmt.Errorf("%w: %s", ErrInvalidTx, err)
 	}
-	return transaction
+	return transaction, nil
 }
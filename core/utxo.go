@@ -0,0 +1,448 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+)
+
+var (
+	utxoPrefix   = []byte("utxo-")
+	prefixLength = len(utxoPrefix)
+)
+
+type UTXOSet struct {
+	Blockchain *BlockChain
+}
+
+const collectSize = 100000
+
+// FindSpendableCandidates lists every unspent output locked to one of the
+// public key hashes
+func (u UTXOSet) FindSpendableCandidates(pubKeyHashes ...[]byte) ([]SpendableOutput, error) {
+	var candidates []SpendableOutput
+	db := u.Blockchain.Database
+
+	err := db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
+			item := it.Item()
+			k := item.KeyCopy(nil)
+			v, err := item.Value()
+			if err != nil {
+				return err
+			}
+			txId := bytes.TrimPrefix(k, utxoPrefix)
+			outs, err := DeserializeOutputs(v)
+			if err != nil {
+				return err
+			}
+
+			for i, out := range outs.Outputs {
+				for _, pubKeyHash := range pubKeyHashes {
+					if out.KeyLocked(pubKeyHash) {
+						candidates = append(candidates, SpendableOutput{Outpoint{txId, outs.Index(i)}, out})
+						break
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return candidates, nil
+}
+
+// FindSpendableOutputs chooses the outputs of pubKeyHashes that pay for amount.
+// The pinned outpoints are always spent and the selector covers what is left.
+// Outputs in spent, keyed by Outpoint.String, are left out, they belong to
+// transactions that wait in the memory pool.
+func (u UTXOSet) FindSpendableOutputs(pubKeyHashes [][]byte, amount int, selector CoinSelector, pinned []Outpoint, spent map[string]bool) (int, []SpendableOutput, error) {
+	if selector == nil {
+		selector = AutoSelect{}
+	}
+
+	all, err := u.FindSpendableCandidates(pubKeyHashes...)
+	if err != nil {
+		return 0, nil, err
+	}
+	var candidates []SpendableOutput
+	for _, candidate := range all {
+		if !spent[candidate.Outpoint.String()] {
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	var selected []SpendableOutput
+	used := make(map[string]bool)
+	for _, outpoint := range pinned {
+		found := false
+		for _, candidate := range candidates {
+			if bytes.Equal(candidate.TxID, outpoint.TxID) && candidate.Index == outpoint.Index {
+				if !used[outpoint.String()] {
+					selected = append(selected, candidate)
+					used[outpoint.String()] = true
+				}
+				found = true
+				break
+			}
+		}
+		if !found && spent[outpoint.String()] {
+			return 0, nil, fmt.Errorf("outpoint %s is spent by a pending transaction", outpoint)
+		}
+		if !found {
+			return 0, nil, fmt.Errorf("outpoint %s is not spendable by this wallet", outpoint)
+		}
+	}
+
+	accumulated := sumOutputs(selected)
+	if accumulated < amount {
+		var rest []SpendableOutput
+		for _, candidate := range candidates {
+			if !used[candidate.Outpoint.String()] {
+				rest = append(rest, candidate)
+			}
+		}
+
+		more, err := selector.Select(rest, amount-accumulated)
+		if err != nil {
+			return 0, nil, err
+		}
+		selected = append(selected, more...)
+		accumulated += sumOutputs(more)
+	}
+
+	return accumulated, selected, nil
+}
+
+func (u UTXOSet) FindUTXO(pubKeyHash []byte) ([]TxOutput, error) {
+	var UTXOs []TxOutput
+
+	db := u.Blockchain.Database
+
+	err := db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
+			item := it.Item()
+			v, err := item.Value()
+			if err != nil {
+				return err
+			}
+			outs, err := DeserializeOutputs(v)
+			if err != nil {
+				return err
+			}
+
+			for _, out := range outs.Outputs {
+				if out.KeyLocked(pubKeyHash) {
+					UTXOs = append(UTXOs, out)
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return UTXOs, nil
+}
+
+// Balance sums the unspent outputs of pubKeyHash
+func (u UTXOSet) Balance(pubKeyHash []byte) (int, error) {
+	UTXOs, err := u.FindUTXO(pubKeyHash)
+	if err != nil {
+		return 0, err
+	}
+
+	balance := 0
+	for _, out := range UTXOs {
+		balance += out.Amount
+	}
+	return balance, nil
+}
+
+func (u UTXOSet) CountTransactions() (int, error) {
+	db := u.Blockchain.Database
+	counter := 0
+
+	err := db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
+			counter++
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return counter, nil
+}
+
+func (u UTXOSet) Reindex() error {
+	db := u.Blockchain.Database
+
+	if err := u.DeleteByPrefix(utxoPrefix); err != nil {
+		return err
+	}
+
+	UTXO, err := u.Blockchain.FindUTXO()
+	if err != nil {
+		return err
+	}
+
+	err = db.Update(func(txn *badger.Txn) error {
+		for txId, outs := range UTXO {
+			key, err := hex.DecodeString(txId)
+			if err != nil {
+				return err
+			}
+			key = append(utxoPrefix, key...)
+
+			err = txn.Set(key, outs.Serialize())
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return TxHistory{u.Blockchain}.Reindex()
+}
+
+// Update spends the outputs the transactions of block spend and adds their
+// outputs, and adds the block to the history, in one database transaction.
+// The block must be on the chain already and be the newest block.
+func (u *UTXOSet) Update(block *Block) error {
+	return u.apply(block, false)
+}
+
+// connect adds a block that extends the newest block to the chain, in the
+// same database transaction as the changes Update makes
+func (u *UTXOSet) connect(block *Block) error {
+	if err := u.apply(block, true); err != nil {
+		return err
+	}
+	u.Blockchain.LatestHash = block.Hash
+	return nil
+}
+
+func (u *UTXOSet) apply(block *Block, addBlock bool) error {
+	history := TxHistory{u.Blockchain}
+	entries, err := history.blockEntries(block)
+	if err != nil {
+		return err
+	}
+
+	return u.Blockchain.Database.Update(func(txn *badger.Txn) error {
+		if addBlock {
+			if _, err := putBlock(txn, block); err != nil {
+				return err
+			}
+		}
+		if err := spendOutputs(txn, block); err != nil {
+			return err
+		}
+		return history.writeEntries(txn, entries)
+	})
+}
+
+// spendOutputs makes the changes of the transactions of block to the UTXO
+// set inside a database transaction
+func spendOutputs(txn *badger.Txn, block *Block) error {
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() == false {
+			for _, in := range tx.TxInputs {
+				updatedOuts := TxOutputs{}
+				inID := append(utxoPrefix, in.Id...)
+				item, err := txn.Get(inID)
+				if err == badger.ErrKeyNotFound {
+					return fmt.Errorf("%w: input %x:%d is not unspent", ErrInvalidTx, in.Id, in.OutIndex)
+				}
+				if err != nil {
+					return err
+				}
+				v, err := item.Value()
+				if err != nil {
+					return err
+				}
+
+				outs, err := DeserializeOutputs(v)
+				if err != nil {
+					return err
+				}
+
+				for outIdx, out := range outs.Outputs {
+					if outs.Index(outIdx) != in.OutIndex {
+						updatedOuts.Outputs = append(updatedOuts.Outputs, out)
+						updatedOuts.Indexes = append(updatedOuts.Indexes, outs.Index(outIdx))
+					}
+				}
+
+				if len(updatedOuts.Outputs) == 0 {
+					if err := txn.Delete(inID); err != nil {
+						return err
+					}
+
+				} else {
+					if err := txn.Set(inID, updatedOuts.Serialize()); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		newOutputs := TxOutputs{}
+		for outIdx, out := range tx.TxOutputs {
+			newOutputs.Outputs = append(newOutputs.Outputs, out)
+			newOutputs.Indexes = append(newOutputs.Indexes, outIdx)
+		}
+
+		txID := append(utxoPrefix, tx.Id...)
+		if err := txn.Set(txID, newOutputs.Serialize()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CheckInputs checks that every input of tx spends an unspent output that is
+// not in spent, and that the inputs cover the outputs. The outputs tx spends
+// are added to spent, keyed by Outpoint.String, and the fee of tx returned.
+// Errors wrap ErrInvalidTx.
+func (u UTXOSet) CheckInputs(tx *Transaction, spent map[string]bool) (fee int, err error) {
+	err = u.Blockchain.Database.View(func(txn *badger.Txn) error {
+		fee, err = checkInputs(tx, spent, func(in TxInput) (TxOutput, bool, error) {
+			return unspentOutput(txn, in)
+		})
+		return err
+	})
+	return fee, err
+}
+
+// checkInputs is CheckInputs with the unspent outputs found by unspent
+func checkInputs(tx *Transaction, spent map[string]bool, unspent func(in TxInput) (TxOutput, bool, error)) (fee int, err error) {
+	var outpoints []string
+	for _, in := range tx.TxInputs {
+		outpoint := Outpoint{in.Id, in.OutIndex}.String()
+		if spent[outpoint] {
+			return 0, fmt.Errorf("%w: %x spends %s twice", ErrInvalidTx, tx.Id, outpoint)
+		}
+		out, ok, err := unspent(in)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			return 0, fmt.Errorf("%w: input %s of %x is not unspent", ErrInvalidTx, outpoint, tx.Id)
+		}
+		fee += out.Amount
+		outpoints = append(outpoints, outpoint)
+	}
+
+	for _, out := range tx.TxOutputs {
+		if out.Amount < 0 {
+			return 0, fmt.Errorf("%w: %x pays a negative amount", ErrInvalidTx, tx.Id)
+		}
+		fee -= out.Amount
+	}
+	if fee < 0 {
+		return 0, fmt.Errorf("%w: %x pays %d more than its inputs", ErrInvalidTx, tx.Id, -fee)
+	}
+	for _, outpoint := range outpoints {
+		spent[outpoint] = true
+	}
+	return fee, nil
+}
+
+// unspentOutput reads the output in spends from the UTXO set, ok is false
+// when it is not there
+func unspentOutput(txn *badger.Txn, in TxInput) (out TxOutput, ok bool, err error) {
+	item, err := txn.Get(append(append([]byte{}, utxoPrefix...), in.Id...))
+	if err == badger.ErrKeyNotFound {
+		return TxOutput{}, false, nil
+	}
+	if err != nil {
+		return TxOutput{}, false, err
+	}
+	v, err := item.Value()
+	if err != nil {
+		return TxOutput{}, false, err
+	}
+	outs, err := DeserializeOutputs(v)
+	if err != nil {
+		return TxOutput{}, false, err
+	}
+	for i, out := range outs.Outputs {
+		if outs.Index(i) == in.OutIndex {
+			return out, true, nil
+		}
+	}
+	return TxOutput{}, false, nil
+}
+
+func (u *UTXOSet) DeleteByPrefix(prefix []byte) error {
+	deleteKeys := func(keysForDelete [][]byte) error {
+		if err := u.Blockchain.Database.Update(func(txn *badger.Txn) error {
+			for _, key := range keysForDelete {
+				if err := txn.Delete(key); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	return u.Blockchain.Database.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		keysForDelete := make([][]byte, 0, collectSize)
+		keysCollected := 0
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			key := it.Item().KeyCopy(nil)
+			keysForDelete = append(keysForDelete, key)
+			keysCollected++
+			if keysCollected == collectSize {
+				if err := deleteKeys(keysForDelete); err != nil {
+					return err
+				}
+				keysForDelete = make([][]byte, 0, collectSize)
+				keysCollected = 0
+			}
+		}
+		if keysCollected > 0 {
+			if err := deleteKeys(keysForDelete); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
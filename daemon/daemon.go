@@ -0,0 +1,97 @@
+package daemon
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"syscall"
+
+	"github.com/vrecan/death"
+	"minhlpc/build_blockchain/api"
+	"minhlpc/build_blockchain/core"
+	"minhlpc/build_blockchain/p2p"
+	"minhlpc/build_blockchain/wallet"
+)
+
+type Config struct {
+	// NodeID names the files of the node, the data directory is picked with
+	// core.SetDataDir
+	NodeID string
+	// ListenAddr and ExternalAddr are where the node accepts peers and where
+	// it tells them to connect, see p2p.Config
+	ListenAddr   string
+	ExternalAddr string
+	// MinerAddress receives the rewards of mined blocks, the node does not
+	// mine without it
+	MinerAddress string
+	// APIAddr is where the REST API listens, it is off when empty
+	APIAddr string
+	// Connect and AddNode pick the peers of the node, see p2p.Config
+	Connect []string
+	AddNode []string
+	// Encrypt and RequireEncryption pick whether peers talk over TLS, see
+	// p2p.Config
+	Encrypt           bool
+	RequireEncryption bool
+}
+
+// Run serves the node until a service fails or the process is interrupted,
+// then closes the chain
+func Run(cfg Config) error {
+	chain, err := core.LoadBlockchain(cfg.NodeID)
+	if err != nil {
+		return err
+	}
+	defer chain.Update(func() error {
+		return chain.Database.Close()
+	})
+
+	node, err := p2p.NewNode(chain, p2p.Config{
+		NodeID:       cfg.NodeID,
+		ListenAddr:   cfg.ListenAddr,
+		ExternalAddr: cfg.ExternalAddr,
+		MinerAddress: cfg.MinerAddress,
+		Connect:      cfg.Connect,
+		AddNode:      cfg.AddNode,
+
+		Encrypt:           cfg.Encrypt,
+		RequireEncryption: cfg.RequireEncryption,
+	})
+	if err != nil {
+		return err
+	}
+	defer node.Close()
+	log.Printf("node identity is %s", node.Identity)
+
+	wallets := wallet.NewManager(cfg.NodeID)
+	rpcServer, err := api.NewRPCServer(node, cfg.NodeID, wallets)
+	if err != nil {
+		return err
+	}
+	defer rpcServer.Close()
+
+	done := make(chan error, 4)
+	go func() {
+		done <- fmt.Errorf("p2p server: %w", node.ListenAndServe())
+	}()
+	go func() {
+		done <- fmt.Errorf("rpc server: %w", rpcServer.Serve())
+	}()
+	if cfg.APIAddr != "" {
+		go func() {
+			done <- fmt.Errorf("rest api: %w", api.NewRouter(node, wallets).Run(cfg.APIAddr))
+		}()
+	}
+	go func() {
+		d := death.NewDeath(syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
+		d.WaitForDeathWithFunc(func() {
+			done <- nil
+		})
+	}()
+
+	err = <-done
+	if err == nil {
+		log.Println("shutting down")
+	}
+	return err
+}
@@ -0,0 +1,4 @@
+// Package daemon runs a node as one long lived process. It opens the chain
+// once and shares it between the P2P server, the miner, the RPC service used
+// by the command line client and, when enabled, the REST API.
+package daemon
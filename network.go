@@ -1,492 +0,0 @@
-package main
-
-import (
-	"bytes"
-	"encoding/gob"
-	"encoding/hex"
-	"fmt"
-	"io"
-	"io/ioutil"
-	"log"
-	"net"
-	"os"
-	"runtime"
-	"syscall"
-
-	"github.com/vrecan/death"
-)
-
-const (
-	protocol      = "tcp"
-	commandLength = 12
-)
-
-var (
-	nodeAddress     string
-	mineAddress     string
-	KnownNodes      = []string{"localhost:3000"}
-	blocksInTransit = [][]byte{}
-	memoryPool      = make(map[string]Transaction)
-)
-
-type Address struct {
-	AddressList []string
-}
-
-type AddressBlock struct {
-	AddressFrom string
-	Block       []byte
-}
-
-type GetBlocks struct {
-	AddressFrom string
-}
-
-type GetData struct {
-	AddressFrom string
-	Type        string
-	ID          []byte
-}
-
-type Inv struct {
-	AddressFrom string
-	Type        string
-	Items       [][]byte
-}
-
-type Tx struct {
-	AddressFrom string
-	Transaction []byte
-}
-
-type Version struct {
-	Version     int
-	BestHeight  int
-	AddressFrom string
-}
-
-func CmdToBytes(cmd string) []byte {
-	var bytes [commandLength]byte
-
-	for i, c := range cmd {
-		bytes[i] = byte(c)
-	}
-
-	return bytes[:]
-}
-
-func BytesToCmd(bytes []byte) string {
-	var cmd []byte
-
-	for _, b := range bytes {
-		if b != 0x0 {
-			cmd = append(cmd, b)
-		}
-	}
-
-	return fmt.Sprintf("%s", cmd)
-}
-
-func ExtractCmd(request []byte) []byte {
-	return request[:commandLength]
-}
-
-func RequestBlocks() {
-	for _, node := range KnownNodes {
-		SendGetBlocks(node)
-	}
-}
-
-func SendAddress(address string) {
-	nodes := Address{KnownNodes}
-	nodes.AddressList = append(nodes.AddressList, nodeAddress)
-	payload := GobEncode(nodes)
-	request := append(CmdToBytes("Address"), payload...)
-
-	SendData(address, request)
-}
-
-func SendBlock(address string, b *Block) {
-	data := AddressBlock{nodeAddress, b.Serialize()}
-	payload := GobEncode(data)
-	request := append(CmdToBytes("block"), payload...)
-
-	SendData(address, request)
-}
-
-func SendData(address string, data []byte) {
-	conn, err := net.Dial(protocol, address)
-
-	if err != nil {
-		fmt.Printf("%s is not available\n", address)
-		var updatedNodes []string
-
-		for _, node := range KnownNodes {
-			if node != address {
-				updatedNodes = append(updatedNodes, node)
-			}
-		}
-
-		KnownNodes = updatedNodes
-
-		return
-	}
-
-	defer conn.Close()
-
-	_, err = io.Copy(conn, bytes.NewReader(data))
-	if err != nil {
-		log.Panic(err)
-	}
-}
-
-func SendInv(address, kind string, items [][]byte) {
-	inventory := Inv{nodeAddress, kind, items}
-	payload := GobEncode(inventory)
-	request := append(CmdToBytes("inv"), payload...)
-
-	SendData(address, request)
-}
-
-func SendGetBlocks(address string) {
-	payload := GobEncode(GetBlocks{nodeAddress})
-	request := append(CmdToBytes("getblocks"), payload...)
-
-	SendData(address, request)
-}
-
-func SendGetData(address, kind string, id []byte) {
-	payload := GobEncode(GetData{nodeAddress, kind, id})
-	request := append(CmdToBytes("getdata"), payload...)
-
-	SendData(address, request)
-}
-
-func SendTx(addr string, tnx *Transaction) {
-	data := Tx{nodeAddress, tnx.Serialize()}
-	payload := GobEncode(data)
-	request := append(CmdToBytes("tx"), payload...)
-
-	SendData(addr, request)
-}
-
-func SendVersion(address string, chain *BlockChain) {
-	bestHeight := chain.GetBestHeight()
-	payload := GobEncode(Version{int(version), bestHeight, nodeAddress})
-
-	request := append(CmdToBytes("version"), payload...)
-
-	SendData(address, request)
-}
-
-func HandleAddr(request []byte) {
-	var buff bytes.Buffer
-	var payload Address
-
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
-
-	}
-
-	KnownNodes = append(KnownNodes, payload.AddressList...)
-	fmt.Printf("there are %d known nodes\n", len(KnownNodes))
-	RequestBlocks()
-}
-
-func HandleBlock(request []byte, chain *BlockChain) {
-	var buff bytes.Buffer
-	var payload AddressBlock
-
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	blockData := payload.Block
-	block := Deserialize(blockData)
-
-	fmt.Println("Recevied a new block!")
-	chain.AddBlock(block)
-
-	fmt.Printf("Added block %x\n", block.Hash)
-
-	if len(blocksInTransit) > 0 {
-		blockHash := blocksInTransit[0]
-		SendGetData(payload.AddressFrom, "block", blockHash)
-
-		blocksInTransit = blocksInTransit[1:]
-	} else {
-		UTXOSet := UTXOSet{chain}
-		UTXOSet.Reindex()
-	}
-}
-
-func HandleInv(request []byte, chain *BlockChain) {
-	var buff bytes.Buffer
-	var payload Inv
-
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	fmt.Printf("Recevied inventory with %d %s\n", len(payload.Items), payload.Type)
-
-	if payload.Type == "block" {
-		blocksInTransit = payload.Items
-
-		blockHash := payload.Items[0]
-		SendGetData(payload.AddressFrom, "block", blockHash)
-
-		newInTransit := [][]byte{}
-		for _, b := range blocksInTransit {
-			if bytes.Compare(b, blockHash) != 0 {
-				newInTransit = append(newInTransit, b)
-			}
-		}
-		blocksInTransit = newInTransit
-	}
-
-	if payload.Type == "tx" {
-		txID := payload.Items[0]
-
-		if memoryPool[hex.EncodeToString(txID)].Id == nil {
-			SendGetData(payload.AddressFrom, "tx", txID)
-		}
-	}
-}
-
-func HandleGetBlocks(request []byte, chain *BlockChain) {
-	var buff bytes.Buffer
-	var payload GetBlocks
-
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	blocks := chain.GetBlockHashes()
-	SendInv(payload.AddressFrom, "block", blocks)
-}
-
-func HandleGetData(request []byte, chain *BlockChain) {
-	var buff bytes.Buffer
-	var payload GetData
-
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	if payload.Type == "block" {
-		block, err := chain.GetBlock([]byte(payload.ID))
-		if err != nil {
-			return
-		}
-
-		SendBlock(payload.AddressFrom, &block)
-	}
-
-	if payload.Type == "tx" {
-		txID := hex.EncodeToString(payload.ID)
-		tx := memoryPool[txID]
-
-		SendTx(payload.AddressFrom, &tx)
-	}
-}
-
-func HandleTx(request []byte, chain *BlockChain) {
-	var buff bytes.Buffer
-	var payload Tx
-
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	txData := payload.Transaction
-	tx := DeserializeTransaction(txData)
-	memoryPool[hex.EncodeToString(tx.Id)] = tx
-
-	fmt.Printf("%s, %d", nodeAddress, len(memoryPool))
-
-	if nodeAddress == KnownNodes[0] {
-		for _, node := range KnownNodes {
-			if node != nodeAddress && node != payload.AddressFrom {
-				SendInv(node, "tx", [][]byte{tx.Id})
-			}
-		}
-	} else {
-		if len(memoryPool) >= 2 && len(mineAddress) > 0 {
-			MineTx(chain)
-		}
-	}
-}
-
-func MineTx(chain *BlockChain) {
-	var txs []*Transaction
-
-	for id := range memoryPool {
-		fmt.Printf("tx: %s\n", memoryPool[id].Id)
-		tx := memoryPool[id]
-		if chain.VerifyTransaction(&tx) {
-			txs = append(txs, &tx)
-		}
-	}
-
-	if len(txs) == 0 {
-		fmt.Println("All Transactions are invalid")
-		return
-	}
-
-	cbTx := CreateCoinbaseTx(mineAddress, "")
-	txs = append(txs, cbTx)
-
-	newBlock := chain.MineBlock(txs)
-	UTXOSet := UTXOSet{chain}
-	UTXOSet.Reindex()
-
-	fmt.Println("New Block mined")
-
-	for _, tx := range txs {
-		txID := hex.EncodeToString(tx.Id)
-		delete(memoryPool, txID)
-	}
-
-	for _, node := range KnownNodes {
-		if node != nodeAddress {
-			SendInv(node, "block", [][]byte{newBlock.Hash})
-		}
-	}
-
-	if len(memoryPool) > 0 {
-		MineTx(chain)
-	}
-}
-
-func HandleVersion(request []byte, chain *BlockChain) {
-	var buff bytes.Buffer
-	var payload Version
-
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	bestHeight := chain.GetBestHeight()
-	otherHeight := payload.BestHeight
-
-	if bestHeight < otherHeight {
-		SendGetBlocks(payload.AddressFrom)
-	} else if bestHeight > otherHeight {
-		SendVersion(payload.AddressFrom, chain)
-	}
-
-	if !NodeIsKnown(payload.AddressFrom) {
-		KnownNodes = append(KnownNodes, payload.AddressFrom)
-	}
-}
-
-func HandleConnection(conn net.Conn, chain *BlockChain) {
-	req, err := ioutil.ReadAll(conn)
-	defer conn.Close()
-
-	if err != nil {
-		log.Panic(err)
-	}
-	command := BytesToCmd(req[:commandLength])
-	fmt.Printf("Received %s command\n", command)
-
-	switch command {
-	case "addr":
-		HandleAddr(req)
-	case "block":
-		HandleBlock(req, chain)
-	case "inv":
-		HandleInv(req, chain)
-	case "getblocks":
-		HandleGetBlocks(req, chain)
-	case "getdata":
-		HandleGetData(req, chain)
-	case "tx":
-		HandleTx(req, chain)
-	case "version":
-		HandleVersion(req, chain)
-	default:
-		fmt.Println("Unknown command")
-	}
-
-}
-
-func StartServer(nodeId, minerAddress string) {
-	nodeAddress = fmt.Sprintf("localhost:%s", nodeId)
-	mineAddress = minerAddress
-	ln, err := net.Listen(protocol, nodeAddress)
-	if err != nil {
-		log.Panic(err)
-	}
-	defer ln.Close()
-
-	chain := LoadBlockchain(nodeId)
-	defer chain.Database.Close()
-	go CloseDB(chain)
-
-	if nodeAddress != KnownNodes[0] {
-		SendVersion(KnownNodes[0], chain)
-	}
-	for {
-		conn, err := ln.Accept()
-		if err != nil {
-			log.Panic(err)
-		}
-		go HandleConnection(conn, chain)
-
-	}
-}
-
-func GobEncode(data interface{}) []byte {
-	var buff bytes.Buffer
-
-	enc := gob.NewEncoder(&buff)
-	err := enc.Encode(data)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	return buff.Bytes()
-}
-
-func NodeIsKnown(addr string) bool {
-	for _, node := range KnownNodes {
-		if node == addr {
-			return true
-		}
-	}
-
-	return false
-}
-
-func CloseDB(chain *BlockChain) {
-	d := death.NewDeath(syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
-
-	d.WaitForDeathWithFunc(func() {
-		defer os.Exit(1)
-		defer runtime.Goexit()
-		chain.Database.Close()
-	})
-}
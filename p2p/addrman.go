@@ -0,0 +1,357 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	mrand "math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"minhlpc/build_blockchain/wallet"
+)
+
+// The address book remembers the nodes a node has heard of, so it finds its
+// peers again after a restart. Addresses it was only told about sit in the new
+// table, addresses it completed a handshake with move to the tried table.
+// Both tables are split into buckets picked by a hash keyed with a secret of
+// the book. A new address lands in a bucket picked by its own group and the
+// group of the node that sent it, and the addresses sent by one group can only
+// reach newBucketsPerSource buckets. The addresses of one group can only reach
+// triedBucketsPerGroup tried buckets. A full bucket pushes out its oldest
+// entry. So a peer, or a network range, that floods the node with addresses
+// can only take over a few buckets and leaves the others alone.
+
+const (
+	newBucketCount       = 256
+	triedBucketCount     = 64
+	bucketSize           = 64
+	newBucketsPerSource  = 32
+	triedBucketsPerGroup = 8
+
+	// maxAddrPerMessage bounds the addresses of one addr message
+	maxAddrPerMessage = 1000
+	// addrRelayLimit is the size up to which an addr message is an
+	// announcement that is passed on, larger ones answer a getaddr
+	addrRelayLimit = 10
+	// retryDelay keeps Select from offering an address that failed its last
+	// attempt a moment ago
+	retryDelay = time.Minute
+)
+
+type knownAddress struct {
+	Address   string
+	Source    string
+	LastSeen  time.Time
+	LastTried time.Time
+	Attempts  int
+	Tried     bool
+}
+
+// addrBookFile is what the book keeps on disk, the buckets are worked out
+// again from the key when it is loaded
+type addrBookFile struct {
+	Key       [32]byte
+	Addresses []knownAddress
+}
+
+// AddrBook is the address book of a node, safe for concurrent use
+type AddrBook struct {
+	file string
+
+	lock         sync.Mutex
+	key          [32]byte
+	addrs        map[string]*knownAddress
+	newBuckets   [newBucketCount][]string
+	triedBuckets [triedBucketCount][]string
+}
+
+// LoadAddrBook reads the book kept in file, or starts an empty one when there
+// is no such file
+func LoadAddrBook(file string) (*AddrBook, error) {
+	book := &AddrBook{file: file, addrs: make(map[string]*knownAddress)}
+
+	content, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		if _, err := rand.Read(book.key[:]); err != nil {
+			return nil, err
+		}
+		return book, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var saved addrBookFile
+	if err := gob.NewDecoder(bytes.NewReader(content)).Decode(&saved); err != nil {
+		return nil, fmt.Errorf("%s is not an address book: %w", file, err)
+	}
+	book.key = saved.Key
+	for _, ka := range saved.Addresses {
+		ka := ka
+		book.addrs[ka.Address] = &ka
+		if ka.Tried {
+			book.addTried(&ka)
+		} else {
+			book.addNew(&ka)
+		}
+	}
+	return book, nil
+}
+
+// Save writes the book to its file
+func (book *AddrBook) Save() error {
+	book.lock.Lock()
+	saved := addrBookFile{Key: book.key}
+	for _, ka := range book.addrs {
+		saved.Addresses = append(saved.Addresses, *ka)
+	}
+	book.lock.Unlock()
+
+	var buff bytes.Buffer
+	if err := gob.NewEncoder(&buff).Encode(saved); err != nil {
+		return err
+	}
+	return wallet.WriteFileAtomic(book.file, buff.Bytes(), 0644)
+}
+
+func (book *AddrBook) Len() int {
+	book.lock.Lock()
+	defer book.lock.Unlock()
+	return len(book.addrs)
+}
+
+// Add puts the addresses source told us about in the new table and returns
+// the ones the book did not know yet
+func (book *AddrBook) Add(addrs []string, source string) []string {
+	book.lock.Lock()
+	defer book.lock.Unlock()
+
+	var added []string
+	now := time.Now()
+	for _, addr := range addrs {
+		if !validAddress(addr) {
+			continue
+		}
+		if ka, ok := book.addrs[addr]; ok {
+			ka.LastSeen = now
+			continue
+		}
+		ka := &knownAddress{Address: addr, Source: source, LastSeen: now}
+		book.addrs[addr] = ka
+		book.addNew(ka)
+		added = append(added, addr)
+	}
+	return added
+}
+
+// Has tells whether the book knows addr
+func (book *AddrBook) Has(addr string) bool {
+	book.lock.Lock()
+	defer book.lock.Unlock()
+	_, ok := book.addrs[addr]
+	return ok
+}
+
+// Good moves addr to the tried table after a handshake with it
+func (book *AddrBook) Good(addr string) {
+	if !validAddress(addr) {
+		return
+	}
+
+	book.lock.Lock()
+	defer book.lock.Unlock()
+
+	now := time.Now()
+	ka, ok := book.addrs[addr]
+	if !ok {
+		ka = &knownAddress{Address: addr, Source: addr}
+		book.addrs[addr] = ka
+	} else if !ka.Tried {
+		book.removeFrom(book.newBuckets[:], ka.Address)
+	}
+	ka.LastSeen = now
+	ka.LastTried = now
+	ka.Attempts = 0
+	if !ka.Tried {
+		book.addTried(ka)
+	}
+}
+
+// Attempt records a connection attempt to addr
+func (book *AddrBook) Attempt(addr string) {
+	book.lock.Lock()
+	defer book.lock.Unlock()
+
+	if ka, ok := book.addrs[addr]; ok {
+		ka.LastTried = time.Now()
+		ka.Attempts++
+	}
+}
+
+// Select picks an address to connect to, half of the time from the tried
+// table when both tables have some. Addresses skip tells about and addresses
+// that failed less than retryDelay ago are passed over.
+func (book *AddrBook) Select(skip func(addr string) bool) (string, bool) {
+	book.lock.Lock()
+	defer book.lock.Unlock()
+
+	tables := [][][]string{book.newBuckets[:], book.triedBuckets[:]}
+	if mrand.Intn(2) == 0 {
+		tables[0], tables[1] = tables[1], tables[0]
+	}
+	for _, buckets := range tables {
+		var candidates []string
+		for _, bucket := range buckets {
+			for _, addr := range bucket {
+				ka := book.addrs[addr]
+				if skip(addr) || ka.Attempts > 0 && time.Since(ka.LastTried) < retryDelay {
+					continue
+				}
+				candidates = append(candidates, addr)
+			}
+		}
+		if len(candidates) > 0 {
+			return candidates[mrand.Intn(len(candidates))], true
+		}
+	}
+	return "", false
+}
+
+// Addresses returns up to max addresses of the book in random order
+func (book *AddrBook) Addresses(max int) []string {
+	book.lock.Lock()
+	defer book.lock.Unlock()
+
+	addrs := make([]string, 0, len(book.addrs))
+	for addr := range book.addrs {
+		addrs = append(addrs, addr)
+	}
+	mrand.Shuffle(len(addrs), func(i, j int) {
+		addrs[i], addrs[j] = addrs[j], addrs[i]
+	})
+	if len(addrs) > max {
+		addrs = addrs[:max]
+	}
+	return addrs
+}
+
+// addNew files ka in its new bucket, pushing out the entry seen longest ago
+// when the bucket is full
+func (book *AddrBook) addNew(ka *knownAddress) {
+	b := book.newBucket(ka.Address, ka.Source)
+	if len(book.newBuckets[b]) >= bucketSize {
+		oldest := book.oldest(book.newBuckets[b], func(ka *knownAddress) time.Time { return ka.LastSeen })
+		book.removeFrom(book.newBuckets[:], oldest)
+		delete(book.addrs, oldest)
+	}
+	book.newBuckets[b] = append(book.newBuckets[b], ka.Address)
+}
+
+// addTried files ka in its tried bucket. When the bucket is full the entry
+// tried longest ago goes back to the new table.
+func (book *AddrBook) addTried(ka *knownAddress) {
+	b := book.triedBucket(ka.Address)
+	if len(book.triedBuckets[b]) >= bucketSize {
+		oldest := book.oldest(book.triedBuckets[b], func(ka *knownAddress) time.Time { return ka.LastTried })
+		book.removeFrom(book.triedBuckets[:], oldest)
+		evicted := book.addrs[oldest]
+		evicted.Tried = false
+		book.addNew(evicted)
+	}
+	ka.Tried = true
+	book.triedBuckets[b] = append(book.triedBuckets[b], ka.Address)
+}
+
+func (book *AddrBook) oldest(bucket []string, when func(*knownAddress) time.Time) string {
+	oldest := bucket[0]
+	for _, addr := range bucket[1:] {
+		if when(book.addrs[addr]).Before(when(book.addrs[oldest])) {
+			oldest = addr
+		}
+	}
+	return oldest
+}
+
+func (book *AddrBook) removeFrom(buckets [][]string, addr string) {
+	for b, bucket := range buckets {
+		for i, a := range bucket {
+			if a == addr {
+				buckets[b] = append(bucket[:i], bucket[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (book *AddrBook) newBucket(addr, source string) int {
+	sourceGroup := addrGroup(source)
+	slot := book.hash(addrGroup(addr), sourceGroup) % newBucketsPerSource
+	return int(book.hash(sourceGroup, strconv.FormatUint(slot, 10)) % newBucketCount)
+}
+
+func (book *AddrBook) triedBucket(addr string) int {
+	slot := book.hash(addr) % triedBucketsPerGroup
+	return int(book.hash(addrGroup(addr), strconv.FormatUint(slot, 10)) % triedBucketCount)
+}
+
+func (book *AddrBook) hash(parts ...string) uint64 {
+	h := sha256.New()
+	h.Write(book.key[:])
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return binary.BigEndian.Uint64(h.Sum(nil))
+}
+
+// addrGroup is the network range of an address: the first two bytes of an
+// IPv4 address, the first four of an IPv6 address, or the host name
+func addrGroup(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return strings.ToLower(host)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d", ip4[0], ip4[1])
+	}
+	return ip.Mask(net.CIDRMask(32, 128)).String()
+}
+
+func validAddress(addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return false
+	}
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n < 65536
+}
+
+// ParseAddressList reads a comma separated list of host:port addresses, an
+// empty string gives an empty list
+func ParseAddressList(s string) ([]string, error) {
+	var addrs []string
+	for _, addr := range strings.Split(s, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		if !validAddress(addr) {
+			return nil, fmt.Errorf("%q is not a host:port address", addr)
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
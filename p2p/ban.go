@@ -0,0 +1,194 @@
+package p2p
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"minhlpc/build_blockchain/core"
+	"minhlpc/build_blockchain/wallet"
+)
+
+// A peer that breaks the protocol collects ban score, how much depends on the
+// error its message caused: an invalid block costs banThreshold at once, a
+// malformed or unsolicited message 20 and an invalid transaction 10. A peer
+// that reaches banThreshold is dropped and its host banned for
+// DefaultBanDuration. Bans are kept on disk, a banned host can neither
+// connect to the node nor be dialed by it until its ban ends.
+
+const (
+	banThreshold       = 100
+	DefaultBanDuration = 24 * time.Hour
+)
+
+var (
+	ErrBanned = errors.New("host is banned")
+	// errUnsolicited is wrapped by errors about data the node did not ask for
+	errUnsolicited = errors.New("unsolicited data")
+)
+
+// misbehavior is an error that costs the peer that caused it score instead
+// of what its kind costs
+type misbehavior struct {
+	score int
+	err   error
+}
+
+func misbehaving(score int, err error) error {
+	return &misbehavior{score, err}
+}
+
+func (m *misbehavior) Error() string {
+	return m.err.Error()
+}
+
+func (m *misbehavior) Unwrap() error {
+	return m.err
+}
+
+// banScore is what err costs the peer whose message caused it
+func banScore(err error) int {
+	var m *misbehavior
+	switch {
+	case errors.As(err, &m):
+		return m.score
+	case errors.Is(err, core.ErrInvalidBlock):
+		return banThreshold
+	case errors.Is(err, core.ErrMalformedMessage), errors.Is(err, errUnsolicited):
+		return 20
+	case errors.Is(err, core.ErrInvalidTx):
+		return 10
+	}
+	return 0
+}
+
+type Ban struct {
+	Host   string
+	Until  time.Time
+	Reason string
+}
+
+// BanList is the list of banned hosts of a node, safe for concurrent use
+type BanList struct {
+	file string
+
+	lock sync.Mutex
+	bans map[string]Ban
+}
+
+// LoadBanList reads the bans kept in file, or starts an empty list when there
+// is no such file
+func LoadBanList(file string) (*BanList, error) {
+	list := &BanList{file: file, bans: make(map[string]Ban)}
+
+	content, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var saved []Ban
+	if err := gob.NewDecoder(bytes.NewReader(content)).Decode(&saved); err != nil {
+		return nil, fmt.Errorf("%s is not a ban list: %w", file, err)
+	}
+	for _, ban := range saved {
+		list.bans[ban.Host] = ban
+	}
+	return list, nil
+}
+
+// Save writes the bans that did not end yet to the file of the list
+func (list *BanList) Save() error {
+	var buff bytes.Buffer
+	if err := gob.NewEncoder(&buff).Encode(list.List()); err != nil {
+		return err
+	}
+	return wallet.WriteFileAtomic(list.file, buff.Bytes(), 0644)
+}
+
+// Ban bans the host of addr until the given time and saves the list
+func (list *BanList) Ban(addr string, until time.Time, reason string) error {
+	host := banHost(addr)
+	if host == "" {
+		return fmt.Errorf("%q is not a host to ban", addr)
+	}
+
+	list.lock.Lock()
+	list.bans[host] = Ban{host, until, reason}
+	list.lock.Unlock()
+	return list.Save()
+}
+
+// Unban lifts the ban of the host of addr and saves the list, ok is false
+// when the host is not banned
+func (list *BanList) Unban(addr string) (ok bool, err error) {
+	host := banHost(addr)
+
+	list.lock.Lock()
+	_, ok = list.bans[host]
+	delete(list.bans, host)
+	list.lock.Unlock()
+	if !ok {
+		return false, nil
+	}
+	return true, list.Save()
+}
+
+// Clear lifts every ban and saves the list
+func (list *BanList) Clear() error {
+	list.lock.Lock()
+	list.bans = make(map[string]Ban)
+	list.lock.Unlock()
+	return list.Save()
+}
+
+// IsBanned tells whether the host of addr is banned now
+func (list *BanList) IsBanned(addr string) bool {
+	list.lock.Lock()
+	defer list.lock.Unlock()
+	ban, ok := list.bans[banHost(addr)]
+	return ok && time.Now().Before(ban.Until)
+}
+
+// List returns the bans that did not end yet, sorted by host
+func (list *BanList) List() []Ban {
+	list.lock.Lock()
+	defer list.lock.Unlock()
+
+	now := time.Now()
+	bans := make([]Ban, 0, len(list.bans))
+	for host, ban := range list.bans {
+		if !now.Before(ban.Until) {
+			delete(list.bans, host)
+			continue
+		}
+		bans = append(bans, ban)
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].Host < bans[j].Host
+	})
+	return bans
+}
+
+// banHost is the host of a host:port address, or addr itself when it has no
+// port. IP addresses are written the canonical way.
+func banHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return strings.ToLower(host)
+}
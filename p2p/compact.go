@@ -0,0 +1,278 @@
+package p2p
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"minhlpc/build_blockchain/core"
+)
+
+// New blocks are relayed as compact blocks: the header, a short id for each
+// transaction and the transactions the receiver cannot have, the coinbase.
+// Short ids are cut from a hash of the block hash and the transaction id, so
+// they differ from block to block. The receiver queues the header like any
+// other and finds the transactions in its memory pool, and asks the sender
+// for the ones it misses with getblocktxn. A block that does not match its
+// header once rebuilt, because two transactions share a short id, is asked
+// for in full.
+
+// shortIDMask keeps the 6 bytes of a short id
+const shortIDMask = 1<<48 - 1
+
+type PrefilledTx struct {
+	Index int
+	Tx    []byte
+}
+
+type CompactBlock struct {
+	AddressFrom string
+	Header      core.BlockHeader
+	// ShortIDs are the short ids of the transactions that are not prefilled,
+	// in block order
+	ShortIDs  []uint64
+	Prefilled []PrefilledTx
+}
+
+type GetBlockTxn struct {
+	AddressFrom string
+	BlockHash   []byte
+	Indexes     []int
+}
+
+type BlockTxn struct {
+	AddressFrom string
+	BlockHash   []byte
+	Txs         [][]byte
+}
+
+// partialBlock is a compact block with transactions missing
+type partialBlock struct {
+	header  core.BlockHeader
+	txs     []*core.Transaction
+	missing []int
+	from    string
+}
+
+func shortTxID(blockHash, txID []byte) uint64 {
+	h := sha256.New()
+	h.Write(blockHash)
+	h.Write(txID)
+	return binary.LittleEndian.Uint64(h.Sum(nil)) & shortIDMask
+}
+
+func newCompactBlock(from string, block *core.Block) CompactBlock {
+	compact := CompactBlock{AddressFrom: from, Header: block.Header()}
+	for i, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			compact.Prefilled = append(compact.Prefilled, PrefilledTx{i, tx.Serialize()})
+			continue
+		}
+		compact.ShortIDs = append(compact.ShortIDs, shortTxID(block.Hash, tx.Id))
+	}
+	return compact
+}
+
+// relayBlock sends a block that became the newest one as a compact block to
+// every ready peer that does not know it yet
+func (n *Node) relayBlock(block *core.Block) {
+	compact := GobEncode(newCompactBlock(n.Address, block))
+	for _, p := range n.peers.ready() {
+		if !p.known.add("block", block.Hash) {
+			continue
+		}
+		if err := p.Send("cmpctblock", compact); err != nil {
+			log.Printf("sending block %x to %s failed: %s", block.Hash, p, err)
+		}
+	}
+}
+
+// inFlightFrom records that the block of hash was asked for from peer
+func (d *blockDownload) inFlightFrom(hash []byte, peer string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.inFlight[string(hash)] = blockRequest{peer, time.Now()}
+}
+
+func (d *blockDownload) addPartial(partial *partialBlock) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.partial[string(partial.header.Hash)] = partial
+	d.inFlight[string(partial.header.Hash)] = blockRequest{partial.from, time.Now()}
+}
+
+// pending tells whether the block of hash arrived or waits for its missing
+// transactions
+func (d *blockDownload) pending(hash []byte) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	_, received := d.received[string(hash)]
+	_, partial := d.partial[string(hash)]
+	return received || partial
+}
+
+// takePartial removes the partial block of hash that waits for transactions
+// from peer, nil when there is none
+func (d *blockDownload) takePartial(hash []byte, peer string) *partialBlock {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	partial := d.partial[string(hash)]
+	if partial == nil || partial.from != peer {
+		return nil
+	}
+	delete(d.partial, string(hash))
+	return partial
+}
+
+// HandleCompactBlock queues the header of a compact block and rebuilds the
+// block from the memory pool, asking the sender for the transactions the
+// pool misses
+func (n *Node) HandleCompactBlock(p *Peer, request []byte) error {
+	var payload CompactBlock
+	if err := gobDecode(request, &payload); err != nil {
+		return err
+	}
+
+	header := payload.Header
+	if err := header.Validate(); err != nil {
+		return err
+	}
+	n.peerKnows(p, "block", header.Hash)
+	fmt.Printf("Received compact block %x\n", header.Hash)
+
+	count := len(payload.ShortIDs) + len(payload.Prefilled)
+	if count == 0 {
+		return fmt.Errorf("%w: block %x without transactions", core.ErrMalformedMessage, header.Hash)
+	}
+	txs := make([]*core.Transaction, count)
+	for i, prefilled := range payload.Prefilled {
+		if prefilled.Index < 0 || prefilled.Index >= count || i > 0 && prefilled.Index <= payload.Prefilled[i-1].Index {
+			return fmt.Errorf("%w: prefilled transaction at %d", core.ErrMalformedMessage, prefilled.Index)
+		}
+		tx, err := core.DeserializeTransaction(prefilled.Tx)
+		if err != nil {
+			return err
+		}
+		txs[prefilled.Index] = &tx
+	}
+
+	var known bool
+	err := n.Chain.View(func() error {
+		var err error
+		known, err = n.Chain.HasBlock(header.Hash)
+		if err != nil || known {
+			return err
+		}
+		return n.download.addHeaders(n.Chain, []core.BlockHeader{header})
+	})
+	if errors.Is(err, errHeadersDoNotConnect) {
+		return n.SendGetHeaders(p)
+	}
+	if err != nil || known || !n.download.queued(header.Hash) || n.download.pending(header.Hash) {
+		return err
+	}
+
+	// short ids two pool transactions share match neither of them
+	pool := make(map[uint64]*core.Transaction)
+	for _, tx := range n.poolTxs() {
+		tx := tx
+		id := shortTxID(header.Hash, tx.Id)
+		if _, ok := pool[id]; ok {
+			pool[id] = nil
+			continue
+		}
+		pool[id] = &tx
+	}
+	var missing []int
+	next := 0
+	for i := range txs {
+		if txs[i] != nil {
+			continue
+		}
+		if tx := pool[payload.ShortIDs[next]]; tx != nil {
+			txs[i] = tx
+		} else {
+			missing = append(missing, i)
+		}
+		next++
+	}
+
+	if len(missing) == 0 {
+		return n.completeBlock(p, header, txs)
+	}
+	fmt.Printf("Asking for %d transactions of block %x\n", len(missing), header.Hash)
+	n.download.addPartial(&partialBlock{header, txs, missing, p.id()})
+	return p.Send("getblocktxn", GobEncode(GetBlockTxn{n.Address, header.Hash, missing}))
+}
+
+// completeBlock adds a block rebuilt from a compact block of p. One that does
+// not match its header is asked for in full.
+func (n *Node) completeBlock(p *Peer, header core.BlockHeader, txs []*core.Transaction) error {
+	block := header.Block(txs)
+	if !header.Matches(block) {
+		fmt.Printf("Block %x does not match its header, asking for all of it\n", header.Hash)
+		n.download.inFlightFrom(header.Hash, p.id())
+		return n.SendGetData(p, "block", header.Hash)
+	}
+
+	if _, err := n.download.receive(block, p); err != nil {
+		return err
+	}
+	n.finishRequest("block", header.Hash)
+	return n.connectBlocks()
+}
+
+func (n *Node) HandleGetBlockTxn(p *Peer, request []byte) error {
+	var payload GetBlockTxn
+	if err := gobDecode(request, &payload); err != nil {
+		return err
+	}
+
+	var block core.Block
+	err := n.Chain.View(func() error {
+		var err error
+		block, err = n.Chain.GetBlock(payload.BlockHash)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	txs := make([][]byte, 0, len(payload.Indexes))
+	for _, i := range payload.Indexes {
+		if i < 0 || i >= len(block.Transactions) {
+			return fmt.Errorf("%w: block %x has no transaction %d", core.ErrMalformedMessage, block.Hash, i)
+		}
+		txs = append(txs, block.Transactions[i].Serialize())
+	}
+	return p.Send("blocktxn", GobEncode(BlockTxn{n.Address, block.Hash, txs}))
+}
+
+// HandleBlockTxn fills in the transactions a compact block missed, they must
+// come from the peer they were asked from
+func (n *Node) HandleBlockTxn(p *Peer, request []byte) error {
+	var payload BlockTxn
+	if err := gobDecode(request, &payload); err != nil {
+		return err
+	}
+
+	partial := n.download.takePartial(payload.BlockHash, p.id())
+	if partial == nil {
+		return fmt.Errorf("%w: transactions of block %x", errUnsolicited, payload.BlockHash)
+	}
+	if len(payload.Txs) != len(partial.missing) {
+		return fmt.Errorf("%w: %d transactions for %d missing", core.ErrMalformedMessage, len(payload.Txs), len(partial.missing))
+	}
+	for i, data := range payload.Txs {
+		tx, err := core.DeserializeTransaction(data)
+		if err != nil {
+			return err
+		}
+		partial.txs[partial.missing[i]] = &tx
+	}
+	fmt.Printf("Received %d transactions of block %x\n", len(payload.Txs), payload.BlockHash)
+	return n.completeBlock(p, partial.header, partial.txs)
+}
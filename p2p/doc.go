@@ -0,0 +1,6 @@
+// Package p2p connects nodes over TCP, optionally encrypted with TLS: it
+// exchanges versions, headers, blocks and transactions with its peers,
+// downloads blocks headers first, keeps an address book of the nodes it heard
+// of, bans peers that break the protocol and mines the mempool on miner
+// nodes.
+package p2p
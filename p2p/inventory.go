@@ -0,0 +1,139 @@
+package p2p
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"minhlpc/build_blockchain/core"
+)
+
+// Nodes tell their peers about the transactions and blocks they accept with
+// inv messages. For each peer a node remembers the items it announced to it
+// or heard of from it, and does not announce those to it again, so an item
+// does not echo back to where it came from. An item announced by several
+// peers is asked for from the first of them only, and from another one when
+// it did not arrive within requestTimeout.
+
+const (
+	// maxKnownInventory bounds the items remembered for one peer, the oldest
+	// are forgotten first
+	maxKnownInventory = 5000
+	requestTimeout    = time.Minute
+)
+
+// knownInventory is the set of items a peer knows, the zero value is empty
+type knownInventory struct {
+	lock  sync.Mutex
+	items map[string]bool
+	order []string
+}
+
+// add remembers the item and tells whether it was new
+func (known *knownInventory) add(kind string, id []byte) bool {
+	known.lock.Lock()
+	defer known.lock.Unlock()
+
+	key := invKey(kind, id)
+	if known.items[key] {
+		return false
+	}
+	if known.items == nil {
+		known.items = make(map[string]bool)
+	}
+	if len(known.order) >= maxKnownInventory {
+		delete(known.items, known.order[0])
+		known.order = known.order[1:]
+	}
+	known.items[key] = true
+	known.order = append(known.order, key)
+	return true
+}
+
+func invKey(kind string, id []byte) string {
+	return kind + ":" + string(id)
+}
+
+// relayInv announces an item to every ready peer that does not know it yet
+func (n *Node) relayInv(kind string, id []byte) {
+	announcement := GobEncode(Inv{n.Address, kind, [][]byte{id}})
+	for _, p := range n.peers.ready() {
+		if !p.known.add(kind, id) {
+			continue
+		}
+		if err := p.Send("inv", announcement); err != nil {
+			log.Printf("announcing %s %x to %s failed: %s", kind, id, p, err)
+		}
+	}
+}
+
+// peerKnows remembers that p knows the items, and so every other connection
+// to the same node
+func (n *Node) peerKnows(p *Peer, kind string, ids ...[]byte) {
+	for _, other := range n.peers.sameNode(p) {
+		for _, id := range ids {
+			other.known.add(kind, id)
+		}
+	}
+}
+
+// startRequest tells whether the item should be asked for, which is when it
+// was not asked for within requestTimeout, and records the request
+func (n *Node) startRequest(kind string, id []byte) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	now := time.Now()
+	for key, sent := range n.requested {
+		if now.Sub(sent) >= requestTimeout {
+			delete(n.requested, key)
+		}
+	}
+	key := invKey(kind, id)
+	if _, ok := n.requested[key]; ok {
+		return false
+	}
+	n.requested[key] = now
+	return true
+}
+
+// finishRequest forgets the request of an item that arrived
+func (n *Node) finishRequest(kind string, id []byte) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	delete(n.requested, invKey(kind, id))
+}
+
+// SubmitTx takes a transaction made on this node like one received from a
+// peer. The caller must not hold the chain.
+func (n *Node) SubmitTx(tx *core.Transaction) error {
+	return n.acceptTx(*tx)
+}
+
+// AnnounceBlock sends the peers a block added to the chain outside of the
+// node
+func (n *Node) AnnounceBlock(block *core.Block) {
+	n.relayBlock(block)
+}
+
+// acceptTx puts a checked transaction in the memory pool and announces it.
+// A transaction spending outputs the chain or the pool already spends is
+// refused. A miner mines the pool once it holds two transactions.
+func (n *Node) acceptTx(tx core.Transaction) error {
+	var poolSize int
+	var added bool
+	err := n.Chain.View(func() error {
+		var err error
+		poolSize, added, err = n.addToPool(tx, core.UTXOSet{Blockchain: n.Chain})
+		return err
+	})
+	if err != nil || !added {
+		return err
+	}
+	n.relayInv("tx", tx.Id)
+
+	if poolSize >= 2 && len(n.MinerAddress) > 0 {
+		return n.MineTx()
+	}
+	return nil
+}
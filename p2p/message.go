@@ -0,0 +1,118 @@
+package p2p
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"minhlpc/build_blockchain/core"
+)
+
+// Every message travels in a frame:
+//
+//	magic    4 bytes  magic of the network, see NetParams
+//	command 12 bytes  ASCII, padded with zero bytes
+//	length   4 bytes  little endian length of the payload
+//	checksum 4 bytes  core.CheckSum of the payload
+//	payload
+//
+// so several messages can follow each other on one connection. A frame with
+// the wrong magic, a bad command, a payload over maxPayloadLength or a wrong
+// checksum ends the connection.
+
+const (
+	headerLength     = 4 + commandLength + 4 + core.ChecksumLength
+	maxPayloadLength = 32 << 20
+	// payloadTimeout bounds how long a peer may take to send the payload once
+	// the header arrived
+	payloadTimeout = time.Minute
+)
+
+type message struct {
+	Command string
+	Payload []byte
+}
+
+// validCommand checks a padded command: printable ASCII followed by nothing
+// but zero bytes
+func validCommand(cmd []byte) bool {
+	end := bytes.IndexByte(cmd, 0)
+	if end == 0 {
+		return false
+	}
+	if end < 0 {
+		end = len(cmd)
+	}
+	for _, b := range cmd[:end] {
+		if b < 0x21 || b > 0x7e {
+			return false
+		}
+	}
+	for _, b := range cmd[end:] {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func encodeMessage(command string, payload []byte) ([]byte, error) {
+	if len(command) == 0 || len(command) > commandLength {
+		return nil, fmt.Errorf("command %q does not fit in %d bytes", command, commandLength)
+	}
+	if len(payload) > maxPayloadLength {
+		return nil, fmt.Errorf("%s payload of %d bytes is over the limit of %d", command, len(payload), maxPayloadLength)
+	}
+
+	magic := core.ActiveNet().Magic
+	frame := make([]byte, 0, headerLength+len(payload))
+	frame = append(frame, magic[:]...)
+	frame = append(frame, CmdToBytes(command)...)
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(payload)))
+	frame = append(frame, length[:]...)
+	frame = append(frame, core.CheckSum(payload)...)
+	return append(frame, payload...), nil
+}
+
+// readMessage reads the next frame of a connection. It returns io.EOF when the
+// peer closed the connection between two frames, errors about the frame
+// itself wrap core.ErrMalformedMessage.
+func readMessage(conn net.Conn) (message, error) {
+	var header [headerLength]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return message{}, err
+	}
+
+	magic := core.ActiveNet().Magic
+	if !bytes.Equal(header[:4], magic[:]) {
+		return message{}, fmt.Errorf("%w: magic %x is not the one of %s", core.ErrMalformedMessage, header[:4], core.ActiveNet().Name)
+	}
+	cmd := header[4 : 4+commandLength]
+	if !validCommand(cmd) {
+		return message{}, fmt.Errorf("%w: command %q", core.ErrMalformedMessage, cmd)
+	}
+	length := binary.LittleEndian.Uint32(header[4+commandLength:])
+	if length > maxPayloadLength {
+		return message{}, misbehaving(banThreshold, fmt.Errorf("%w: payload of %d bytes is over the limit of %d", core.ErrMalformedMessage, length, maxPayloadLength))
+	}
+
+	payload := make([]byte, length)
+	conn.SetReadDeadline(time.Now().Add(payloadTimeout))
+	_, err := io.ReadFull(conn, payload)
+	conn.SetReadDeadline(time.Time{})
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return message{}, err
+	}
+	if !bytes.Equal(header[4+commandLength+4:], core.CheckSum(payload)) {
+		return message{}, fmt.Errorf("%w: checksum of %s does not match", core.ErrMalformedMessage, BytesToCmd(cmd))
+	}
+
+	return message{BytesToCmd(cmd), payload}, nil
+}
@@ -0,0 +1,561 @@
+package p2p
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"minhlpc/build_blockchain/core"
+)
+
+const (
+	protocol        = "tcp"
+	protocolVersion = 1
+	commandLength   = 12
+)
+
+// seedNodes are the nodes every new Node knows
+var seedNodes = []string{"localhost:3000"}
+
+// SelectNetwork switches the process to the named network and seeds new
+// nodes with the seed node of that network
+func SelectNetwork(name string) error {
+	if err := core.SelectNetwork(name); err != nil {
+		return err
+	}
+	seedNodes = []string{fmt.Sprintf("localhost:%s", core.ActiveNet().DefaultPort)}
+	return nil
+}
+
+type Address struct {
+	AddressFrom string
+	AddressList []string
+}
+
+type AddressBlock struct {
+	AddressFrom string
+	Block       []byte
+}
+
+type GetAddr struct {
+	AddressFrom string
+}
+
+type GetData struct {
+	AddressFrom string
+	Type        string
+	ID          []byte
+}
+
+type Inv struct {
+	AddressFrom string
+	Type        string
+	Items       [][]byte
+}
+
+type Tx struct {
+	AddressFrom string
+	Transaction []byte
+}
+
+type Version struct {
+	Version     int
+	BestHeight  int
+	AddressFrom string
+	Magic       [4]byte
+}
+
+func CmdToBytes(cmd string) []byte {
+	var bytes [commandLength]byte
+
+	for i, c := range cmd {
+		bytes[i] = byte(c)
+	}
+
+	return bytes[:]
+}
+
+func BytesToCmd(bytes []byte) string {
+	var cmd []byte
+
+	for _, b := range bytes {
+		if b != 0x0 {
+			cmd = append(cmd, b)
+		}
+	}
+
+	return fmt.Sprintf("%s", cmd)
+}
+
+// SendAddress answers a getaddr of p with a sample of the address book
+func (n *Node) SendAddress(p *Peer) error {
+	nodes := Address{n.Address, n.Book.Addresses(maxAddrPerMessage)}
+	payload := GobEncode(nodes)
+	return p.Send("addr", payload)
+}
+
+func (n *Node) SendBlock(p *Peer, b *core.Block) error {
+	data := AddressBlock{n.Address, b.Serialize()}
+	payload := GobEncode(data)
+	return p.Send("block", payload)
+}
+
+func (n *Node) SendGetData(p *Peer, kind string, id []byte) error {
+	payload := GobEncode(GetData{n.Address, kind, id})
+	return p.Send("getdata", payload)
+}
+
+func (n *Node) SendTx(p *Peer, tnx *core.Transaction) error {
+	data := Tx{n.Address, tnx.Serialize()}
+	payload := GobEncode(data)
+	return p.Send("tx", payload)
+}
+
+func (n *Node) bestHeight() (int, error) {
+	var bestHeight int
+	err := n.Chain.View(func() error {
+		var err error
+		bestHeight, err = n.Chain.GetBestHeight()
+		return err
+	})
+	return bestHeight, err
+}
+
+// sendVersion opens the handshake with a peer
+func (n *Node) sendVersion(p *Peer) error {
+	bestHeight, err := n.bestHeight()
+	if err != nil {
+		return err
+	}
+	return p.Send("version", GobEncode(Version{protocolVersion, bestHeight, n.Address, core.ActiveNet().Magic}))
+}
+
+// verifyAddress adds the address an inbound peer announced to the address
+// book once a node of the network answers a version there, the node of the
+// peer when the peer is encrypted. Addresses the book knows, that are banned
+// or being verified are left alone.
+func (n *Node) verifyAddress(p *Peer, address string) {
+	if address == n.Address || n.Book.Has(address) || n.Bans.IsBanned(address) || !n.startRequest("addr", []byte(address)) {
+		return
+	}
+	defer n.finishRequest("addr", []byte(address))
+
+	if err := n.probe(address, p.Identity); err != nil {
+		log.Printf("address %s announced from %s is not verified: %s", address, p.conn.RemoteAddr(), err)
+		return
+	}
+	n.Book.Good(address)
+}
+
+// probe checks that a node of the network answers a version on address. With
+// an identity the node must prove it over TLS.
+func (n *Node) probe(address, identity string) error {
+	conn, got, err := n.transport.dial(address, n.transport.encrypt || identity != "")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if identity != "" && got != identity {
+		return fmt.Errorf("%s has identity %q", address, got)
+	}
+
+	bestHeight, err := n.bestHeight()
+	if err != nil {
+		return err
+	}
+	frame, err := encodeMessage("version", GobEncode(Version{protocolVersion, bestHeight, n.Address, core.ActiveNet().Magic}))
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(writeTimeout))
+	if _, err := conn.Write(frame); err != nil {
+		return err
+	}
+	msg, err := readMessage(conn)
+	if err != nil {
+		return err
+	}
+	var payload Version
+	if msg.Command != "version" {
+		return fmt.Errorf("%s answered %s", address, msg.Command)
+	}
+	if err := gobDecode(msg.Payload, &payload); err != nil {
+		return err
+	}
+	if payload.Magic != core.ActiveNet().Magic || payload.AddressFrom != address {
+		return fmt.Errorf("%s answered as %s on another network", address, payload.AddressFrom)
+	}
+	return nil
+}
+
+func (n *Node) HandleAddr(p *Peer, request []byte) error {
+	var buff bytes.Buffer
+	var payload Address
+
+	buff.Write(request)
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		return fmt.Errorf("%w: %s", core.ErrMalformedMessage, err)
+	}
+
+	if len(payload.AddressList) > maxAddrPerMessage {
+		return misbehaving(banThreshold, fmt.Errorf("%w: %d addresses in one message", core.ErrMalformedMessage, len(payload.AddressList)))
+	}
+
+	var addrs []string
+	for _, addr := range payload.AddressList {
+		if addr != n.Address {
+			addrs = append(addrs, addr)
+		}
+	}
+	added := n.Book.Add(addrs, p.conn.RemoteAddr().String())
+	fmt.Printf("there are %d addresses in the address book\n", n.Book.Len())
+
+	// pass small announcements of new addresses on to two other peers, the
+	// addresses are known by the time they come back so the relay ends
+	if len(payload.AddressList) > addrRelayLimit || len(added) == 0 {
+		return nil
+	}
+	relay := GobEncode(Address{n.Address, added})
+	sent := 0
+	for _, other := range n.peers.ready() {
+		if sent == 2 {
+			break
+		}
+		if other.id() == p.id() {
+			continue
+		}
+		if err := other.Send("addr", relay); err != nil {
+			log.Printf("relaying addresses to %s failed: %s", other, err)
+			continue
+		}
+		sent++
+	}
+	return nil
+}
+
+func (n *Node) HandleGetAddr(p *Peer, request []byte) error {
+	var payload GetAddr
+	if err := gobDecode(request, &payload); err != nil {
+		return err
+	}
+	return n.SendAddress(p)
+}
+
+// HandleBlock keeps a block that was asked for and adds the blocks that can
+// join the chain. Blocks the node did not ask for are refused.
+func (n *Node) HandleBlock(p *Peer, request []byte) error {
+	var payload AddressBlock
+	if err := gobDecode(request, &payload); err != nil {
+		return err
+	}
+
+	block, err := core.Deserialize(payload.Block)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Recevied a new block!")
+	n.peerKnows(p, "block", block.Hash)
+	queued, err := n.download.receive(block, p)
+	if err != nil {
+		return err
+	}
+	if !queued {
+		return fmt.Errorf("%w: block %x", errUnsolicited, block.Hash)
+	}
+	n.finishRequest("block", block.Hash)
+	return n.connectBlocks()
+}
+
+func (n *Node) HandleInv(p *Peer, request []byte) error {
+	var payload Inv
+	if err := gobDecode(request, &payload); err != nil {
+		return err
+	}
+
+	fmt.Printf("Recevied inventory with %d %s\n", len(payload.Items), payload.Type)
+	if len(payload.Items) == 0 {
+		return fmt.Errorf("%w: inventory is empty", core.ErrMalformedMessage)
+	}
+
+	n.peerKnows(p, payload.Type, payload.Items...)
+
+	// the headers of the first new block lead to the ones after it
+	if payload.Type == "block" {
+		for _, blockHash := range payload.Items {
+			var known bool
+			err := n.Chain.View(func() error {
+				var err error
+				known, err = n.Chain.HasBlock(blockHash)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			if known || n.download.queued(blockHash) {
+				continue
+			}
+			if n.startRequest("block", blockHash) {
+				return n.SendGetHeaders(p)
+			}
+			return nil
+		}
+	}
+
+	if payload.Type == "tx" {
+		for _, txID := range payload.Items {
+			if _, ok := n.poolTx(hex.EncodeToString(txID)); ok || !n.startRequest("tx", txID) {
+				continue
+			}
+			if err := n.SendGetData(p, "tx", txID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (n *Node) HandleGetData(p *Peer, request []byte) error {
+	var buff bytes.Buffer
+	var payload GetData
+
+	buff.Write(request)
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		return fmt.Errorf("%w: %s", core.ErrMalformedMessage, err)
+	}
+
+	if payload.Type == "block" {
+		var block core.Block
+		err := n.Chain.View(func() error {
+			var err error
+			block, err = n.Chain.GetBlock([]byte(payload.ID))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		return n.SendBlock(p, &block)
+	}
+
+	if payload.Type == "tx" {
+		txID := hex.EncodeToString(payload.ID)
+		tx, ok := n.poolTx(txID)
+		if !ok {
+			return fmt.Errorf("%w: %s is not in the memory pool", core.ErrTxNotFound, txID)
+		}
+
+		return n.SendTx(p, &tx)
+	}
+	return nil
+}
+
+func (n *Node) HandleTx(p *Peer, request []byte) error {
+	var buff bytes.Buffer
+	var payload Tx
+
+	buff.Write(request)
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		return fmt.Errorf("%w: %s", core.ErrMalformedMessage, err)
+	}
+
+	txData := payload.Transaction
+	tx, err := core.DeserializeTransaction(txData)
+	if err != nil {
+		return err
+	}
+	n.finishRequest("tx", tx.Id)
+	n.peerKnows(p, "tx", tx.Id)
+	if tx.IsCoinbase() {
+		return fmt.Errorf("%w: coinbase %x outside a block", core.ErrInvalidTx, tx.Id)
+	}
+	err = n.Chain.View(func() error {
+		return n.Chain.VerifyTransaction(&tx)
+	})
+	if err != nil {
+		return err
+	}
+	err = n.acceptTx(tx)
+	if errors.Is(err, core.ErrInvalidTx) {
+		// spending outputs the chain or the pool already spends costs the
+		// peer what an invalid transaction does
+		return misbehaving(10, err)
+	}
+	return err
+}
+
+// MineTx mines the transactions of the memory pool into a block. A
+// transaction whose inputs are spent, by the chain or by a transaction picked
+// before it, is dropped from the pool.
+func (n *Node) MineTx() error {
+	var txs, conflicting []*core.Transaction
+	var newBlock *core.Block
+
+	chain := n.Chain
+	err := chain.Update(func() error {
+		UTXOSet := core.UTXOSet{Blockchain: chain}
+		spent := make(map[string]bool)
+		for _, tx := range n.poolTxs() {
+			tx := tx
+			err := chain.VerifyTransaction(&tx)
+			if err == nil {
+				_, err = UTXOSet.CheckInputs(&tx, spent)
+			}
+			if errors.Is(err, core.ErrInvalidTx) {
+				conflicting = append(conflicting, &tx)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			txs = append(txs, &tx)
+		}
+
+		if len(txs) == 0 {
+			return nil
+		}
+
+		cbTx, err := core.CreateCoinbaseTx(n.MinerAddress, "")
+		if err != nil {
+			return err
+		}
+		txs = append(txs, cbTx)
+
+		newBlock, err = chain.MineBlock(txs)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	n.removeFromPool(conflicting)
+	if len(txs) == 0 {
+		fmt.Println("All Transactions are invalid")
+		return nil
+	}
+
+	fmt.Println("New Block mined")
+
+	left := n.removeFromPool(txs)
+
+	n.relayBlock(newBlock)
+
+	if left > 0 {
+		return n.MineTx()
+	}
+	return nil
+}
+
+// HandleVersion completes the handshake with p. Peers of other networks and
+// connections to the node itself, by address or by identity, are dropped.
+func (n *Node) HandleVersion(p *Peer, request []byte) error {
+	var payload Version
+	if err := gobDecode(request, &payload); err != nil {
+		return fmt.Errorf("%w: %s", errDisconnect, err)
+	}
+
+	if payload.Magic != core.ActiveNet().Magic {
+		return fmt.Errorf("%w: %s is on another network", errDisconnect, payload.AddressFrom)
+	}
+	if payload.AddressFrom == n.Address || p.Identity == n.Identity {
+		return fmt.Errorf("%w: connected to itself", errDisconnect)
+	}
+
+	p.lock.Lock()
+	p.versionReceived = true
+	p.bestHeight = payload.BestHeight
+	p.lock.Unlock()
+
+	if p.Inbound {
+		n.peers.register(p, payload.AddressFrom)
+		go n.verifyAddress(p, payload.AddressFrom)
+		if err := n.sendVersion(p); err != nil {
+			return err
+		}
+	}
+	if err := p.Send("verack", nil); err != nil {
+		return err
+	}
+	if !p.Inbound {
+		n.Book.Good(p.Address)
+		if err := p.Send("getaddr", GobEncode(GetAddr{n.Address})); err != nil {
+			return err
+		}
+	}
+
+	bestHeight, err := n.bestHeight()
+	if err != nil {
+		return err
+	}
+
+	if bestHeight < payload.BestHeight {
+		getHeaders, err := n.getHeadersPayload()
+		if err != nil {
+			return err
+		}
+		return p.Send("getheaders", getHeaders)
+	}
+	return nil
+}
+
+// handleMessage runs the handler of a message from p that is not part of the
+// handshake
+func (n *Node) handleMessage(p *Peer, msg message) error {
+	fmt.Printf("Received %s command\n", msg.Command)
+
+	switch msg.Command {
+	case "addr":
+		return n.HandleAddr(p, msg.Payload)
+	case "block":
+		return n.HandleBlock(p, msg.Payload)
+	case "blocktxn":
+		return n.HandleBlockTxn(p, msg.Payload)
+	case "cmpctblock":
+		return n.HandleCompactBlock(p, msg.Payload)
+	case "inv":
+		return n.HandleInv(p, msg.Payload)
+	case "getaddr":
+		return n.HandleGetAddr(p, msg.Payload)
+	case "getheaders":
+		return n.HandleGetHeaders(p, msg.Payload)
+	case "headers":
+		return n.HandleHeaders(p, msg.Payload)
+	case "getblocktxn":
+		return n.HandleGetBlockTxn(p, msg.Payload)
+	case "getdata":
+		return n.HandleGetData(p, msg.Payload)
+	case "tx":
+		return n.HandleTx(p, msg.Payload)
+	}
+	fmt.Println("Unknown command")
+	return nil
+}
+
+// gobDecode reads a payload, errors wrap core.ErrMalformedMessage
+func gobDecode(request []byte, payload interface{}) error {
+	if err := gob.NewDecoder(bytes.NewReader(request)).Decode(payload); err != nil {
+		return fmt.Errorf("%w: %s", core.ErrMalformedMessage, err)
+	}
+	return nil
+}
+
+func GobEncode(data interface{}) []byte {
+	var buff bytes.Buffer
+
+	enc := gob.NewEncoder(&buff)
+	err := enc.Encode(data)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return buff.Bytes()
+}
@@ -0,0 +1,398 @@
+package p2p
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	mrand "math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"minhlpc/build_blockchain/core"
+)
+
+const (
+	// connectInterval is how often a node looks for more outbound peers in
+	// its address book
+	connectInterval = 30 * time.Second
+	// gossipInterval is how often a node announces itself to its peers and
+	// asks one of them for addresses
+	gossipInterval = 10 * time.Minute
+)
+
+var ErrNodeClosed = errors.New("p2p node is closed")
+
+type Config struct {
+	// NodeID names the files of the node, its wallets, chain and address book
+	NodeID string
+	// ListenAddr is where the node accepts peers, localhost:<NodeID> when
+	// empty
+	ListenAddr string
+	// ExternalAddr is the address the node gives its peers to reach it, the
+	// listen address when empty. A listen address without a host is
+	// advertised on localhost.
+	ExternalAddr string
+	// MinerAddress receives the rewards of mined blocks, the node does not
+	// mine without it
+	MinerAddress string
+	// Connect are the only nodes the node connects to, the address book is
+	// not used for outbound peers then
+	Connect []string
+	// AddNode are nodes the node stays connected to next to the peers it
+	// finds in its address book
+	AddNode []string
+	// Encrypt dials peers over TLS, see transport.go. Inbound TLS is
+	// accepted either way.
+	Encrypt bool
+	// RequireEncryption refuses plaintext peers, inbound or outbound, it
+	// implies Encrypt
+	RequireEncryption bool
+}
+
+// Node is the P2P side of a running node. The goroutines of its peers handle
+// messages at the same time, so the known nodes, the memory pool and the
+// requested items are only touched with lock held. Nodes share nothing but
+// the network picked with SelectNetwork, several of them can run in one
+// process.
+type Node struct {
+	// ID names the files of the node
+	ID string
+	// ListenAddr is where the node accepts peers
+	ListenAddr string
+	// Address is where peers reach the node, it is advertised to them
+	Address string
+	// Identity is the hash of the identity key of the node, what peers know
+	// it by over TLS
+	Identity string
+	// MinerAddress receives the rewards of mined blocks, the node does not
+	// mine without it
+	MinerAddress string
+	Chain        *core.BlockChain
+	Book         *AddrBook
+	Bans         *BanList
+
+	peers       *peerManager
+	transport   *transport
+	download    *blockDownload
+	quit        chan struct{}
+	connectOnly bool
+
+	lock       sync.Mutex
+	listener   net.Listener
+	closed     bool
+	knownNodes []string
+	memoryPool map[string]core.Transaction
+	// requested are the items asked for from a peer and when
+	requested map[string]time.Time
+}
+
+// NewNode prepares the node serving chain and loads its address book, its
+// bans and its identity key. Its known nodes are the Connect nodes, or else the AddNode nodes
+// followed by the seed node of the network.
+func NewNode(chain *core.BlockChain, cfg Config) (*Node, error) {
+	listenAddr, address, err := nodeAddresses(cfg)
+	if err != nil {
+		return nil, err
+	}
+	book, err := LoadAddrBook(core.NetPath(".", fmt.Sprintf("peers_%s.dat", cfg.NodeID)))
+	if err != nil {
+		return nil, err
+	}
+	bans, err := LoadBanList(core.NetPath(".", fmt.Sprintf("banned_%s.dat", cfg.NodeID)))
+	if err != nil {
+		return nil, err
+	}
+	transport, err := newTransport(core.NetPath(".", fmt.Sprintf("nodekey_%s.pem", cfg.NodeID)), cfg.Encrypt, cfg.RequireEncryption)
+	if err != nil {
+		return nil, err
+	}
+
+	knownNodes := append(append([]string{}, cfg.AddNode...), seedNodes...)
+	if len(cfg.Connect) > 0 {
+		knownNodes = append([]string{}, cfg.Connect...)
+	}
+	n := &Node{
+		ID:           cfg.NodeID,
+		ListenAddr:   listenAddr,
+		Address:      address,
+		Identity:     transport.identity,
+		MinerAddress: cfg.MinerAddress,
+		Chain:        chain,
+		Book:         book,
+		Bans:         bans,
+		transport:    transport,
+		download:     newBlockDownload(),
+		quit:         make(chan struct{}),
+		connectOnly:  len(cfg.Connect) > 0,
+		memoryPool:   make(map[string]core.Transaction),
+		requested:    make(map[string]time.Time),
+	}
+	n.peers = &peerManager{node: n, byAddr: make(map[string]*Peer)}
+	n.addKnownNodes(knownNodes...)
+	return n, nil
+}
+
+// nodeAddresses works out the listen and the advertised address of cfg
+func nodeAddresses(cfg Config) (listenAddr, address string, err error) {
+	listenAddr = cfg.ListenAddr
+	if listenAddr == "" {
+		listenAddr = fmt.Sprintf("localhost:%s", cfg.NodeID)
+	}
+	host, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return "", "", fmt.Errorf("listen address %q: %w", listenAddr, err)
+	}
+
+	address = cfg.ExternalAddr
+	if address == "" {
+		if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+			host = "localhost"
+		}
+		address = net.JoinHostPort(host, port)
+	}
+	if !validAddress(address) {
+		return "", "", fmt.Errorf("%q is not a host:port address to advertise", address)
+	}
+	return listenAddr, address, nil
+}
+
+// ListenAndServe accepts peers and keeps the node connected to the nodes it
+// knew at start until the listener fails or the node is closed. Unless the
+// node only connects to its Connect nodes it also dials addresses of its book
+// while it has free outbound slots.
+func (n *Node) ListenAndServe() error {
+	ln, err := net.Listen(protocol, n.ListenAddr)
+	if err != nil {
+		return err
+	}
+
+	n.lock.Lock()
+	if n.closed {
+		n.lock.Unlock()
+		ln.Close()
+		return ErrNodeClosed
+	}
+	n.listener = ln
+	n.lock.Unlock()
+	defer ln.Close()
+
+	for _, node := range n.KnownNodes() {
+		if node != n.Address {
+			go n.peers.keepConnected(node)
+		}
+	}
+	if !n.connectOnly {
+		go n.fillOutbound()
+	}
+	go n.gossip()
+	go n.retryBlocks()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if n.isClosed() {
+				return ErrNodeClosed
+			}
+			return err
+		}
+		n.peers.accept(conn)
+	}
+}
+
+// fillOutbound dials addresses of the book until the outbound slots are taken
+// or the book has nothing left to offer, every connectInterval
+func (n *Node) fillOutbound() {
+	ticker := time.NewTicker(connectInterval)
+	defer ticker.Stop()
+
+	for {
+		for attempt := 0; attempt < maxOutboundPeers && n.peers.outboundCount() < maxOutboundPeers; attempt++ {
+			addr, ok := n.Book.Select(func(addr string) bool {
+				return addr == n.Address || n.peers.connected(addr) || n.Bans.IsBanned(addr)
+			})
+			if !ok {
+				break
+			}
+			n.Book.Attempt(addr)
+			if _, err := n.peers.connect(addr); err != nil {
+				log.Printf("%s is not available: %s", addr, err)
+			}
+		}
+
+		select {
+		case <-n.quit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// gossip announces the node to its peers, asks one of them for the addresses
+// it knows and saves the book, every gossipInterval
+func (n *Node) gossip() {
+	ticker := time.NewTicker(gossipInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.quit:
+			return
+		case <-ticker.C:
+		}
+
+		ready := n.peers.ready()
+		announcement := GobEncode(Address{n.Address, []string{n.Address}})
+		for _, p := range ready {
+			if err := p.Send("addr", announcement); err != nil {
+				log.Printf("announcing to %s failed: %s", p, err)
+			}
+		}
+		if len(ready) > 0 {
+			p := ready[mrand.Intn(len(ready))]
+			if err := p.Send("getaddr", GobEncode(GetAddr{n.Address})); err != nil {
+				log.Printf("getaddr to %s failed: %s", p, err)
+			}
+		}
+		if err := n.Book.Save(); err != nil {
+			log.Printf("saving the address book failed: %s", err)
+		}
+	}
+}
+
+// Close stops listening, disconnects every peer and saves the address book
+func (n *Node) Close() error {
+	n.lock.Lock()
+	if n.closed {
+		n.lock.Unlock()
+		return nil
+	}
+	n.closed = true
+	close(n.quit)
+	ln := n.listener
+	n.lock.Unlock()
+
+	n.peers.closeAll()
+	if ln != nil {
+		ln.Close()
+	}
+	return n.Book.Save()
+}
+
+// SetBan bans the host of addr for duration and drops its peers
+func (n *Node) SetBan(addr string, duration time.Duration, reason string) error {
+	if err := n.Bans.Ban(addr, time.Now().Add(duration), reason); err != nil {
+		return err
+	}
+	n.peers.dropHost(banHost(addr))
+	return nil
+}
+
+func (n *Node) isClosed() bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.closed
+}
+
+// KnownNodes returns a copy of the addresses the node stays connected to
+func (n *Node) KnownNodes() []string {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return append([]string{}, n.knownNodes...)
+}
+
+func (n *Node) NodeIsKnown(addr string) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.nodeIsKnown(addr)
+}
+
+func (n *Node) nodeIsKnown(addr string) bool {
+	for _, node := range n.knownNodes {
+		if node == addr {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addKnownNodes adds the addresses the node does not know yet and returns how
+// many nodes it knows
+func (n *Node) addKnownNodes(addrs ...string) int {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	for _, addr := range addrs {
+		if !n.nodeIsKnown(addr) {
+			n.knownNodes = append(n.knownNodes, addr)
+		}
+	}
+	return len(n.knownNodes)
+}
+
+// addToPool adds tx to the memory pool and returns the size of the pool,
+// added is false when the pool already had it. The inputs of tx must be
+// unspent in UTXOSet and by the transactions of the pool. The caller holds
+// the chain.
+func (n *Node) addToPool(tx core.Transaction, UTXOSet core.UTXOSet) (size int, added bool, err error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	txID := hex.EncodeToString(tx.Id)
+	if _, ok := n.memoryPool[txID]; ok {
+		return len(n.memoryPool), false, nil
+	}
+	if _, err := UTXOSet.CheckInputs(&tx, n.poolSpent()); err != nil {
+		return len(n.memoryPool), false, err
+	}
+	n.memoryPool[txID] = tx
+	return len(n.memoryPool), true, nil
+}
+
+// SpentByPool returns the outputs the transactions of the memory pool spend,
+// keyed by core.Outpoint.String, so new transactions can leave them out
+func (n *Node) SpentByPool() map[string]bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.poolSpent()
+}
+
+// poolSpent returns the outputs the transactions of the memory pool spend,
+// keyed by core.Outpoint.String. The caller holds n.lock.
+func (n *Node) poolSpent() map[string]bool {
+	spent := make(map[string]bool)
+	for _, tx := range n.memoryPool {
+		for _, in := range tx.TxInputs {
+			spent[core.Outpoint{TxID: in.Id, Index: in.OutIndex}.String()] = true
+		}
+	}
+	return spent
+}
+
+func (n *Node) poolTx(txID string) (core.Transaction, bool) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	tx, ok := n.memoryPool[txID]
+	return tx, ok
+}
+
+// poolTxs returns a copy of the transactions in the memory pool
+func (n *Node) poolTxs() []core.Transaction {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	txs := make([]core.Transaction, 0, len(n.memoryPool))
+	for _, tx := range n.memoryPool {
+		txs = append(txs, tx)
+	}
+	return txs
+}
+
+// removeFromPool drops txs from the memory pool and returns how many
+// transactions are left
+func (n *Node) removeFromPool(txs []*core.Transaction) int {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	for _, tx := range txs {
+		delete(n.memoryPool, hex.EncodeToString(tx.Id))
+	}
+	return len(n.memoryPool)
+}
@@ -0,0 +1,161 @@
+package p2p
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"minhlpc/build_blockchain/core"
+	"minhlpc/build_blockchain/wallet"
+)
+
+// useRegtest runs the test on the regtest network with its files in a
+// temporary directory
+func useRegtest(t *testing.T) {
+	if err := SelectNetwork("regtest"); err != nil {
+		t.Fatal(err)
+	}
+	core.SetDataDir(t.TempDir())
+	for _, dir := range []string{core.NetPath(".", ""), core.NetPath("./db", "")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// newTestChains makes one chain and gives each of nodeIds a copy of it, so
+// the nodes share their genesis block
+func newTestChains(t *testing.T, nodeIds ...string) []*core.BlockChain {
+	address := string(wallet.MakeWallet().Address())
+	chain, err := core.InitMyChain(address, nodeIds[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.Database.Close()
+
+	var chains []*core.BlockChain
+	for _, nodeId := range nodeIds {
+		if nodeId != nodeIds[0] {
+			copyDir(t, core.NetPath("./db", "blocks_"+nodeIds[0]), core.NetPath("./db", "blocks_"+nodeId))
+		}
+		chain, err := core.LoadBlockchain(nodeId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { chain.Database.Close() })
+
+		UTXOSet := core.UTXOSet{Blockchain: chain}
+		if err := UTXOSet.Reindex(); err != nil {
+			t.Fatal(err)
+		}
+		chains = append(chains, chain)
+	}
+	return chains
+}
+
+func copyDir(t *testing.T, from, to string) {
+	if err := os.MkdirAll(to, 0755); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		src, err := os.Open(filepath.Join(from, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst, err := os.Create(filepath.Join(to, entry.Name()))
+		if err == nil {
+			_, err = io.Copy(dst, src)
+			dst.Close()
+		}
+		src.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// freeAddr returns a local address nothing listens on
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen(protocol, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// startNode runs a node serving chain until the test ends
+func startNode(t *testing.T, chain *core.BlockChain, cfg Config) *Node {
+	n, err := NewNode(chain, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- n.ListenAndServe() }()
+	t.Cleanup(func() {
+		if err := n.Close(); err != nil {
+			t.Error(err)
+		}
+		if err := <-served; err != ErrNodeClosed {
+			t.Errorf("serving stopped with %v", err)
+		}
+	})
+	return n
+}
+
+// waitFor checks done until it holds or the test runs out of time
+func waitFor(t *testing.T, what string, done func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestNodesShakeHandsAndRelayABlock(t *testing.T) {
+	useRegtest(t)
+	chains := newTestChains(t, "a", "b")
+	addrA, addrB := freeAddr(t), freeAddr(t)
+
+	// a only connects to itself, which it skips, so b is its only peer
+	a := startNode(t, chains[0], Config{NodeID: "a", ListenAddr: addrA, Connect: []string{addrA}})
+	b := startNode(t, chains[1], Config{NodeID: "b", ListenAddr: addrB, Connect: []string{addrA}})
+
+	waitFor(t, "the handshake", func() bool {
+		return len(a.peers.ready()) == 1 && len(b.peers.ready()) == 1
+	})
+
+	coinbase, err := core.CreateCoinbaseTx(string(wallet.MakeWallet().Address()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var block *core.Block
+	err = a.Chain.Update(func() error {
+		var err error
+		block, err = a.Chain.MineBlock([]*core.Transaction{coinbase})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.AnnounceBlock(block)
+
+	waitFor(t, "the block", func() bool {
+		var relayed bool
+		b.Chain.View(func() error {
+			relayed = bytes.Equal(b.Chain.LatestHash, block.Hash)
+			return nil
+		})
+		return relayed
+	})
+}
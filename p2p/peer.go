@@ -0,0 +1,499 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"minhlpc/build_blockchain/core"
+)
+
+// Peers stay connected for as long as both ends run. The dialing side opens
+// with version, the other side answers with its own version, and each side
+// acknowledges the version it received with verack. Until a peer has sent its
+// version nothing else is accepted from it. Connected peers are pinged every
+// pingInterval and dropped when they stay silent for idleTimeout. The nodes a
+// Node knows at start are dialed again with a growing delay whenever their
+// connection is lost. Messages are answered on the connection they came on,
+// the AddressFrom of a payload is only what the sender claims.
+
+const (
+	maxOutboundPeers = 8
+	maxInboundPeers  = 32
+
+	pingInterval = 2 * time.Minute
+	idleTimeout  = 3 * pingInterval
+	writeTimeout = 30 * time.Second
+
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 5 * time.Minute
+)
+
+var ErrTooManyPeers = errors.New("too many peers")
+
+type Ping struct {
+	Nonce uint64
+}
+
+// Peer is a connection to another node
+type Peer struct {
+	// Address is the listen address of the peer. For inbound peers it is what
+	// their version claims, only known once it arrived, and not trusted.
+	Address string
+	Inbound bool
+	// Identity is the identity the peer proved in the TLS handshake, empty
+	// for a plaintext connection
+	Identity string
+
+	node      *Node
+	conn      net.Conn
+	writeLock sync.Mutex
+	done      chan struct{}
+
+	lock            sync.Mutex
+	versionReceived bool
+	verackReceived  bool
+	pingNonce       uint64
+	bestHeight      int
+	banScore        int
+
+	// known are the items announced to the peer or by it
+	known knownInventory
+}
+
+// Ready tells whether the handshake with the peer is complete
+func (p *Peer) Ready() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.versionReceived && p.verackReceived
+}
+
+// BestHeight is the height of the best chain the peer told about
+func (p *Peer) BestHeight() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.bestHeight
+}
+
+// setBestHeight raises the height the peer told about to height
+func (p *Peer) setBestHeight(height int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if height > p.bestHeight {
+		p.bestHeight = height
+	}
+}
+
+func (p *Peer) String() string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.Address != "" {
+		return p.Address
+	}
+	return p.conn.RemoteAddr().String()
+}
+
+// Send frames and writes one message to the peer
+func (p *Peer) Send(command string, payload []byte) error {
+	frame, err := encodeMessage(command, payload)
+	if err != nil {
+		return err
+	}
+
+	p.writeLock.Lock()
+	defer p.writeLock.Unlock()
+	p.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err = p.conn.Write(frame)
+	if err != nil {
+		p.conn.Close()
+	}
+	return err
+}
+
+// Close disconnects the peer, its read loop ends and it leaves the peer list
+func (p *Peer) Close() {
+	p.conn.Close()
+}
+
+// run reads messages from the peer until the connection ends
+func (p *Peer) run() {
+	defer p.node.peers.remove(p)
+	defer close(p.done)
+	defer p.conn.Close()
+
+	go p.keepAlive()
+
+	for {
+		p.conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		msg, err := readMessage(p.conn)
+		if err != nil {
+			log.Printf("dropping %s: %s", p, err)
+			p.misbehaved(err)
+			return
+		}
+		if err := p.handle(msg); err != nil {
+			log.Printf("%s command from %s failed: %s", msg.Command, p, err)
+			if p.misbehaved(err) || errors.Is(err, errDisconnect) {
+				return
+			}
+		}
+	}
+}
+
+// misbehaved adds what err costs to the ban score of the peer. Once the
+// score reaches banThreshold the host of the peer is banned and banned is
+// true.
+func (p *Peer) misbehaved(err error) (banned bool) {
+	score := banScore(err)
+	if score == 0 {
+		return false
+	}
+
+	p.lock.Lock()
+	p.banScore += score
+	total := p.banScore
+	p.lock.Unlock()
+
+	log.Printf("ban score of %s is %d", p, total)
+	if total < banThreshold {
+		return false
+	}
+	if err := p.node.SetBan(p.host(), DefaultBanDuration, err.Error()); err != nil {
+		log.Printf("banning %s failed: %s", p, err)
+	}
+	return true
+}
+
+// host is the host the connection of the peer comes from, what a ban covers
+func (p *Peer) host() string {
+	return banHost(p.conn.RemoteAddr().String())
+}
+
+// id tells peers apart without trusting what they claim: it is the identity
+// of an encrypted peer, the address an outbound peer was dialed on and the
+// remote address of an inbound one
+func (p *Peer) id() string {
+	if p.Identity != "" {
+		return p.Identity
+	}
+	if !p.Inbound {
+		return p.Address
+	}
+	return p.conn.RemoteAddr().String()
+}
+
+// errDisconnect is wrapped by errors after which the peer is dropped
+var errDisconnect = errors.New("disconnecting")
+
+func (p *Peer) handle(msg message) error {
+	p.lock.Lock()
+	versionReceived := p.versionReceived
+	p.lock.Unlock()
+
+	if !versionReceived && msg.Command != "version" {
+		return fmt.Errorf("%w: %s before version", errDisconnect, msg.Command)
+	}
+
+	switch msg.Command {
+	case "version":
+		if versionReceived {
+			return fmt.Errorf("%w: second version", errDisconnect)
+		}
+		return p.node.HandleVersion(p, msg.Payload)
+	case "verack":
+		p.lock.Lock()
+		p.verackReceived = true
+		p.lock.Unlock()
+		return nil
+	case "ping":
+		return p.Send("pong", msg.Payload)
+	case "pong":
+		return p.handlePong(msg.Payload)
+	}
+	return p.node.handleMessage(p, msg)
+}
+
+// keepAlive pings the peer, a peer that did not answer the previous ping by
+// the next one is dropped
+func (p *Peer) keepAlive() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+
+		p.lock.Lock()
+		unanswered := p.pingNonce != 0
+		p.pingNonce = rand.Uint64() | 1
+		nonce := p.pingNonce
+		p.lock.Unlock()
+
+		if unanswered {
+			log.Printf("%s did not answer ping, dropping it", p)
+			p.Close()
+			return
+		}
+		if err := p.Send("ping", GobEncode(Ping{nonce})); err != nil {
+			return
+		}
+	}
+}
+
+func (p *Peer) handlePong(request []byte) error {
+	var payload Ping
+	if err := gobDecode(request, &payload); err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if payload.Nonce != p.pingNonce {
+		return fmt.Errorf("%w: pong %d does not match ping %d", core.ErrMalformedMessage, payload.Nonce, p.pingNonce)
+	}
+	p.pingNonce = 0
+	return nil
+}
+
+// peerManager keeps the connected peers of a node, the outbound ones by the
+// address they were dialed on, and enforces the limits
+type peerManager struct {
+	lock     sync.Mutex
+	node     *Node
+	byAddr   map[string]*Peer
+	all      map[*Peer]bool
+	inbound  int
+	outbound int
+}
+
+// connect returns the peer connected at address, dialing it if there is none
+func (pm *peerManager) connect(address string) (*Peer, error) {
+	if pm.node.isClosed() {
+		return nil, ErrNodeClosed
+	}
+
+	pm.lock.Lock()
+	if p, ok := pm.byAddr[address]; ok {
+		pm.lock.Unlock()
+		return p, nil
+	}
+	if pm.node.Bans.IsBanned(address) {
+		pm.lock.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrBanned, address)
+	}
+	if pm.outbound >= maxOutboundPeers {
+		pm.lock.Unlock()
+		return nil, fmt.Errorf("%w: %d outbound connections", ErrTooManyPeers, pm.outbound)
+	}
+	pm.outbound++
+	pm.lock.Unlock()
+
+	conn, identity, err := pm.node.transport.dial(address, pm.node.transport.encrypt)
+	if err != nil {
+		pm.lock.Lock()
+		pm.outbound--
+		pm.lock.Unlock()
+		return nil, err
+	}
+
+	p := &Peer{Address: address, Identity: identity, node: pm.node, conn: conn, done: make(chan struct{})}
+	if pm.node.Bans.IsBanned(p.host()) {
+		pm.lock.Lock()
+		pm.outbound--
+		pm.lock.Unlock()
+		conn.Close()
+		return nil, fmt.Errorf("%w: %s is %s", ErrBanned, address, p.host())
+	}
+	pm.lock.Lock()
+	if pm.node.isClosed() {
+		pm.outbound--
+		pm.lock.Unlock()
+		conn.Close()
+		return nil, ErrNodeClosed
+	}
+	if existing, ok := pm.byAddr[address]; ok {
+		pm.outbound--
+		pm.lock.Unlock()
+		conn.Close()
+		return existing, nil
+	}
+	pm.byAddr[address] = p
+	pm.add(p)
+	pm.lock.Unlock()
+
+	go p.run()
+	if err := pm.node.sendVersion(p); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// accept takes an inbound connection unless the inbound slots are full or
+// the host it comes from is banned, once its TLS handshake if any is done
+func (pm *peerManager) accept(conn net.Conn) {
+	if pm.node.Bans.IsBanned(conn.RemoteAddr().String()) {
+		log.Printf("refusing %s: %s", conn.RemoteAddr(), ErrBanned)
+		conn.Close()
+		return
+	}
+	go pm.acceptTransport(conn)
+}
+
+func (pm *peerManager) acceptTransport(raw net.Conn) {
+	conn, identity, err := pm.node.transport.accept(raw)
+	if err != nil {
+		log.Printf("refusing %s: %s", raw.RemoteAddr(), err)
+		raw.Close()
+		return
+	}
+
+	pm.lock.Lock()
+	if pm.node.isClosed() {
+		pm.lock.Unlock()
+		conn.Close()
+		return
+	}
+	if pm.inbound >= maxInboundPeers {
+		pm.lock.Unlock()
+		log.Printf("refusing %s: %s", conn.RemoteAddr(), ErrTooManyPeers)
+		conn.Close()
+		return
+	}
+	pm.inbound++
+	p := &Peer{Inbound: true, Identity: identity, node: pm.node, conn: conn, done: make(chan struct{})}
+	pm.add(p)
+	pm.lock.Unlock()
+
+	go p.run()
+}
+
+// add tracks p until it is removed, pm.lock is held
+func (pm *peerManager) add(p *Peer) {
+	if pm.all == nil {
+		pm.all = make(map[*Peer]bool)
+	}
+	pm.all[p] = true
+}
+
+// register records the address an inbound peer announced. The peer is shown
+// by it but not found under it, any peer can claim any address.
+func (pm *peerManager) register(p *Peer, address string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.Address = address
+}
+
+func (pm *peerManager) remove(p *Peer) {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	if p.Inbound {
+		pm.inbound--
+	} else {
+		pm.outbound--
+	}
+	if !p.Inbound && pm.byAddr[p.Address] == p {
+		delete(pm.byAddr, p.Address)
+	}
+	delete(pm.all, p)
+}
+
+func (pm *peerManager) outboundCount() int {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	return pm.outbound
+}
+
+func (pm *peerManager) connected(address string) bool {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	_, ok := pm.byAddr[address]
+	return ok
+}
+
+// sameNode returns the peers with the id of p, p among them. Two nodes that
+// dial each other have two connections, told to be the same node by their
+// identity when they are encrypted.
+func (pm *peerManager) sameNode(p *Peer) []*Peer {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	id := p.id()
+	peers := []*Peer{p}
+	for other := range pm.all {
+		if other != p && other.id() == id {
+			peers = append(peers, other)
+		}
+	}
+	return peers
+}
+
+// ready returns the peers that completed the handshake
+func (pm *peerManager) ready() []*Peer {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	var ready []*Peer
+	for p := range pm.all {
+		if p.Ready() {
+			ready = append(ready, p)
+		}
+	}
+	return ready
+}
+
+// dropHost disconnects the peers that connect from host or were dialed on it
+func (pm *peerManager) dropHost(host string) {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	for p := range pm.all {
+		if p.host() == host || !p.Inbound && banHost(p.Address) == host {
+			p.Close()
+		}
+	}
+}
+
+// closeAll disconnects every peer
+func (pm *peerManager) closeAll() {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	for p := range pm.all {
+		p.Close()
+	}
+}
+
+// keepConnected dials address until the node is closed, waiting longer after
+// every attempt that did not get through the handshake
+func (pm *peerManager) keepConnected(address string) {
+	delay := minReconnectDelay
+	for {
+		p, err := pm.connect(address)
+		if errors.Is(err, ErrNodeClosed) {
+			return
+		}
+		if err != nil {
+			log.Printf("%s is not available: %s", address, err)
+		} else {
+			<-p.done
+			if p.Ready() {
+				delay = minReconnectDelay
+			}
+		}
+
+		select {
+		case <-pm.node.quit:
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
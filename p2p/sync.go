@@ -0,0 +1,405 @@
+package p2p
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"minhlpc/build_blockchain/core"
+)
+
+// Blocks are downloaded headers first. A node asks a peer for the headers
+// that follow its block locator, checks their proof of work and that they
+// link up, and queues them. The blocks of the first blockWindow queued
+// headers are then requested from the peers that have them, at most
+// maxBlocksPerPeer from one peer at a time, and a request that gets no answer
+// within blockTimeout goes to another peer. Blocks join the chain in the order
+// of their headers as soon as the ones before them arrived, each one updating
+// the UTXO set with its own transactions.
+
+const (
+	maxHeadersPerMessage = 2000
+	maxLocatorLength     = 101
+	blockWindow          = 32
+	maxBlocksPerPeer     = 8
+	blockTimeout         = 30 * time.Second
+)
+
+var errHeadersDoNotConnect = errors.New("headers do not connect to the chain")
+
+type GetHeaders struct {
+	AddressFrom string
+	Locator     [][]byte
+}
+
+type Headers struct {
+	AddressFrom string
+	Headers     []core.BlockHeader
+}
+
+// blockRequest is a block asked for from the peer with the id peer
+type blockRequest struct {
+	peer string
+	sent time.Time
+}
+
+// blockDownload is what a node still has to download. Lock it only while
+// holding the chain, never the other way round.
+type blockDownload struct {
+	lock sync.Mutex
+	// headers are checked headers whose blocks are not in the chain yet, in
+	// chain order
+	headers  []core.BlockHeader
+	inFlight map[string]blockRequest
+	received map[string]receivedBlock
+	// partial are compact blocks waiting for their missing transactions
+	partial map[string]*partialBlock
+}
+
+// receivedBlock is a block that arrived before the ones it builds on
+type receivedBlock struct {
+	block *core.Block
+	from  *Peer
+}
+
+func newBlockDownload() *blockDownload {
+	return &blockDownload{
+		inFlight: make(map[string]blockRequest),
+		received: make(map[string]receivedBlock),
+		partial:  make(map[string]*partialBlock),
+	}
+}
+
+// queued tells whether the header of hash waits for its block
+func (d *blockDownload) queued(hash []byte) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for _, header := range d.headers {
+		if bytes.Equal(header.Hash, hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// addHeaders queues checked headers that follow the queued ones or a block of
+// the chain. Headers of a branch that is not longer than the queued one are
+// dropped. The caller holds the chain.
+func (d *blockDownload) addHeaders(chain *core.BlockChain, headers []core.BlockHeader) error {
+	for len(headers) > 0 {
+		known, err := chain.HasBlock(headers[0].Hash)
+		if err != nil {
+			return err
+		}
+		if !known && !d.queued(headers[0].Hash) {
+			break
+		}
+		headers = headers[1:]
+	}
+	if len(headers) == 0 {
+		return nil
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	first := headers[0]
+	if len(d.headers) > 0 {
+		last := d.headers[len(d.headers)-1]
+		if bytes.Equal(first.PreviousHash, last.Hash) {
+			if first.Height != last.Height+1 {
+				return fmt.Errorf("%w: header %x has height %d after %d", core.ErrInvalidBlock, first.Hash, first.Height, last.Height)
+			}
+			d.headers = append(d.headers, headers...)
+			return nil
+		}
+	}
+
+	parent, err := chain.GetBlock(first.PreviousHash)
+	if errors.Is(err, core.ErrBlockNotFound) {
+		return fmt.Errorf("%w: no block %x", errHeadersDoNotConnect, first.PreviousHash)
+	}
+	if err != nil {
+		return err
+	}
+	if first.Height != parent.Height+1 {
+		return fmt.Errorf("%w: header %x has height %d after %d", core.ErrInvalidBlock, first.Hash, first.Height, parent.Height)
+	}
+	if len(d.headers) > 0 && headers[len(headers)-1].Height <= d.headers[len(d.headers)-1].Height {
+		return nil
+	}
+	d.headers = headers
+	d.inFlight = make(map[string]blockRequest)
+	d.received = make(map[string]receivedBlock)
+	d.partial = make(map[string]*partialBlock)
+	return nil
+}
+
+// receive keeps a block from the given peer that was queued, ok is false when
+// its header is not queued. A block that does not match its header is an
+// error.
+func (d *blockDownload) receive(block *core.Block, from *Peer) (ok bool, err error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for i := range d.headers {
+		if !bytes.Equal(d.headers[i].Hash, block.Hash) {
+			continue
+		}
+		if !d.headers[i].Matches(block) {
+			return false, fmt.Errorf("%w: block %x does not match its header", core.ErrInvalidBlock, block.Hash)
+		}
+		d.received[string(block.Hash)] = receivedBlock{block, from}
+		delete(d.inFlight, string(block.Hash))
+		return true, nil
+	}
+	return false, nil
+}
+
+// next takes the first queued block and the peer it came from when it
+// arrived
+func (d *blockDownload) next() (block *core.Block, from *Peer) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if len(d.headers) == 0 {
+		return nil, nil
+	}
+	received, ok := d.received[string(d.headers[0].Hash)]
+	if !ok {
+		return nil, nil
+	}
+	delete(d.received, string(received.block.Hash))
+	d.headers = d.headers[1:]
+	return received.block, received.from
+}
+
+// reset forgets every queued header
+func (d *blockDownload) reset() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.headers = nil
+	d.inFlight = make(map[string]blockRequest)
+	d.received = make(map[string]receivedBlock)
+	d.partial = make(map[string]*partialBlock)
+}
+
+// getHeadersPayload asks for the headers after the newest queued header, or
+// after the newest block when none is queued
+func (n *Node) getHeadersPayload() ([]byte, error) {
+	var locator [][]byte
+	err := n.Chain.View(func() error {
+		var err error
+		locator, err = n.Chain.BlockLocator()
+		if err != nil {
+			return err
+		}
+
+		n.download.lock.Lock()
+		defer n.download.lock.Unlock()
+		if len(n.download.headers) > 0 {
+			last := n.download.headers[len(n.download.headers)-1]
+			locator = append([][]byte{last.Hash}, locator...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GobEncode(GetHeaders{n.Address, locator}), nil
+}
+
+func (n *Node) SendGetHeaders(p *Peer) error {
+	payload, err := n.getHeadersPayload()
+	if err != nil {
+		return err
+	}
+	return p.Send("getheaders", payload)
+}
+
+func (n *Node) HandleGetHeaders(p *Peer, request []byte) error {
+	var payload GetHeaders
+	if err := gobDecode(request, &payload); err != nil {
+		return err
+	}
+	if len(payload.Locator) > maxLocatorLength {
+		return misbehaving(banThreshold, fmt.Errorf("%w: locator of %d hashes", core.ErrMalformedMessage, len(payload.Locator)))
+	}
+
+	var headers []core.BlockHeader
+	err := n.Chain.View(func() error {
+		var err error
+		headers, err = n.Chain.HeadersAfter(payload.Locator, maxHeadersPerMessage)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return p.Send("headers", GobEncode(Headers{n.Address, headers}))
+}
+
+// HandleHeaders queues the headers a peer sent once they are checked, asks it
+// for more when it sent as many as it could and downloads their blocks
+func (n *Node) HandleHeaders(p *Peer, request []byte) error {
+	var payload Headers
+	if err := gobDecode(request, &payload); err != nil {
+		return err
+	}
+	if len(payload.Headers) > maxHeadersPerMessage {
+		return misbehaving(banThreshold, fmt.Errorf("%w: %d headers in one message", core.ErrMalformedMessage, len(payload.Headers)))
+	}
+
+	fmt.Printf("Received %d headers\n", len(payload.Headers))
+	if len(payload.Headers) == 0 {
+		return nil
+	}
+	for i := range payload.Headers {
+		header := &payload.Headers[i]
+		if err := header.Validate(); err != nil {
+			return err
+		}
+		if i == 0 {
+			continue
+		}
+		previous := payload.Headers[i-1]
+		if !bytes.Equal(header.PreviousHash, previous.Hash) {
+			return fmt.Errorf("%w: headers are not in chain order", core.ErrMalformedMessage)
+		}
+		// the proof of work does not cover the height
+		if header.Height != previous.Height+1 {
+			return misbehaving(banThreshold, fmt.Errorf("%w: header %x has height %d after %d", core.ErrInvalidBlock, header.Hash, header.Height, previous.Height))
+		}
+	}
+
+	err := n.Chain.View(func() error {
+		return n.download.addHeaders(n.Chain, payload.Headers)
+	})
+	if err != nil {
+		return err
+	}
+
+	last := payload.Headers[len(payload.Headers)-1]
+	p.setBestHeight(last.Height)
+	if len(payload.Headers) == maxHeadersPerMessage {
+		if err := n.SendGetHeaders(p); err != nil {
+			return err
+		}
+	}
+	n.requestBlocks()
+	return nil
+}
+
+// requestBlocks asks peers for the blocks of the first blockWindow queued
+// headers that did not arrive and are not asked for, or were asked for more
+// than blockTimeout ago. It prefers the peer with the fewest requests, and
+// another peer than the one that let a request time out.
+func (n *Node) requestBlocks() {
+	type request struct {
+		peer *Peer
+		hash []byte
+	}
+	var requests []request
+
+	ready := n.peers.ready()
+	d := n.download
+	d.lock.Lock()
+	// peers are counted by id, the connections to one encrypted node share it
+	load := make(map[string]int)
+	for _, r := range d.inFlight {
+		if time.Since(r.sent) < blockTimeout {
+			load[r.peer]++
+		}
+	}
+	for i := 0; i < len(d.headers) && i < blockWindow; i++ {
+		header := d.headers[i]
+		key := string(header.Hash)
+		if _, ok := d.received[key]; ok {
+			continue
+		}
+		previous, asked := d.inFlight[key]
+		if asked && time.Since(previous.sent) < blockTimeout {
+			continue
+		}
+
+		var best *Peer
+		for _, p := range ready {
+			id := p.id()
+			if p.BestHeight() < header.Height || load[id] >= maxBlocksPerPeer {
+				continue
+			}
+			if best == nil || load[id] < load[best.id()] ||
+				asked && best.id() == previous.peer && id != previous.peer {
+				best = p
+			}
+		}
+		if best == nil {
+			continue
+		}
+		load[best.id()]++
+		d.inFlight[key] = blockRequest{best.id(), time.Now()}
+		requests = append(requests, request{best, header.Hash})
+	}
+	d.lock.Unlock()
+
+	for _, r := range requests {
+		if err := r.peer.Send("getdata", GobEncode(GetData{n.Address, "block", r.hash})); err != nil {
+			log.Printf("asking %s for block %x failed: %s", r.peer, r.hash, err)
+		}
+	}
+}
+
+// connectBlocks adds the queued blocks that arrived to the chain, in order,
+// takes their transactions out of the memory pool and announces the newest
+// of them. When the chain refuses one, the rest of the queue builds on it and
+// is dropped. The chain checks the block against itself before it refuses
+// it, so the connection that delivered it is charged for an invalid block.
+func (n *Node) connectBlocks() error {
+	var newest *core.Block
+	for {
+		var block *core.Block
+		var from *Peer
+		err := n.Chain.Update(func() error {
+			block, from = n.download.next()
+			if block == nil {
+				return nil
+			}
+			return n.Chain.ConnectBlock(block)
+		})
+		if err != nil {
+			n.download.reset()
+			if !errors.Is(err, core.ErrInvalidBlock) && !errors.Is(err, core.ErrInvalidTx) {
+				return err
+			}
+			from.misbehaved(misbehaving(banThreshold, err))
+			return fmt.Errorf("block %x from %s is refused: %s", block.Hash, from, err)
+		}
+		if block == nil {
+			break
+		}
+		fmt.Printf("Added block %x\n", block.Hash)
+		n.removeFromPool(block.Transactions)
+		newest = block
+	}
+	if newest != nil {
+		n.relayBlock(newest)
+	}
+	n.requestBlocks()
+	return nil
+}
+
+// retryBlocks hands requests that timed out to other peers until the node is
+// closed
+func (n *Node) retryBlocks() {
+	ticker := time.NewTicker(blockTimeout / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.quit:
+			return
+		case <-ticker.C:
+		}
+		n.requestBlocks()
+	}
+}
@@ -0,0 +1,204 @@
+package p2p
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"time"
+
+	"minhlpc/build_blockchain/wallet"
+)
+
+// Connections may run over TLS 1.3. Every node has an identity key kept next
+// to its address book, and shows it in a self-signed certificate that both
+// ends of a connection must present. The identity of a peer is a hash of the
+// key it proved to hold in the handshake, unlike the addresses in payloads it
+// cannot be made up. A node that encrypts dials its peers with TLS and falls
+// back to plaintext for peers that do not answer it, unless it requires
+// encryption. Inbound connections are told apart by their first byte: a TLS
+// record starts with tlsHandshakeRecord, a frame with the network magic.
+
+const tlsHandshakeRecord = 0x16
+
+var ErrNotEncrypted = errors.New("connection is not encrypted")
+
+// transport opens and accepts the connections of a node
+type transport struct {
+	identity string
+	config   *tls.Config
+	encrypt  bool
+	require  bool
+}
+
+// newTransport loads the identity key of the node from keyFile, or makes one
+// when there is no such file
+func newTransport(keyFile string, encrypt, require bool) (*transport, error) {
+	key, err := loadNodeKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := selfSignedCert(key)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := keyIdentity(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS13,
+		ClientAuth:   tls.RequireAnyClientCert,
+		// peers are known by their key and not by a certificate authority,
+		// the handshake checks that the peer holds the key of its certificate
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(certs [][]byte, _ [][]*x509.Certificate) error {
+			if len(certs) != 1 {
+				return fmt.Errorf("peer sent %d certificates", len(certs))
+			}
+			_, err := x509.ParseCertificate(certs[0])
+			return err
+		},
+	}
+	return &transport{identity, config, encrypt || require, require}, nil
+}
+
+// loadNodeKey reads a PEM encoded P-256 key from file, creating it first
+// when there is no such file
+func loadNodeKey(file string) (*ecdsa.PrivateKey, error) {
+	content, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		content := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+		return key, wallet.WriteFileAtomic(file, content, 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil || block.Type != "EC PRIVATE KEY" {
+		return nil, fmt.Errorf("%s is not a node key", file)
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a node key: %w", file, err)
+	}
+	return key, nil
+}
+
+func selfSignedCert(key *ecdsa.PrivateKey) (tls.Certificate, error) {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// keyIdentity is the identity of the node holding the private side of pub,
+// the hex of the first 20 bytes of the hash of the key
+func keyIdentity(pub interface{}) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(der)
+	return hex.EncodeToString(hash[:20]), nil
+}
+
+// dial connects to address, over TLS when encrypt is true. identity is empty
+// for a plaintext connection.
+func (t *transport) dial(address string, encrypt bool) (conn net.Conn, identity string, err error) {
+	conn, err = net.DialTimeout(protocol, address, writeTimeout)
+	if err != nil || !encrypt {
+		return conn, "", err
+	}
+
+	secure := tls.Client(conn, t.config)
+	identity, err = t.handshake(secure)
+	if err == nil {
+		return secure, identity, nil
+	}
+	conn.Close()
+	if t.require {
+		return nil, "", fmt.Errorf("%w: %s", ErrNotEncrypted, err)
+	}
+	log.Printf("TLS with %s failed, connecting in plaintext: %s", address, err)
+	conn, err = net.DialTimeout(protocol, address, writeTimeout)
+	return conn, "", err
+}
+
+// accept runs the TLS handshake of an inbound connection that opens with
+// one. Plaintext connections are refused when the node requires encryption.
+func (t *transport) accept(conn net.Conn) (net.Conn, string, error) {
+	sniffed := &sniffedConn{conn, bufio.NewReader(conn)}
+	conn.SetReadDeadline(time.Now().Add(writeTimeout))
+	first, err := sniffed.reader.Peek(1)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return nil, "", err
+	}
+
+	if first[0] != tlsHandshakeRecord {
+		if t.require {
+			return nil, "", ErrNotEncrypted
+		}
+		return sniffed, "", nil
+	}
+	secure := tls.Server(sniffed, t.config)
+	identity, err := t.handshake(secure)
+	if err != nil {
+		return nil, "", err
+	}
+	return secure, identity, nil
+}
+
+// handshake completes the TLS handshake of conn and returns the identity of
+// the peer
+func (t *transport) handshake(conn *tls.Conn) (string, error) {
+	conn.SetDeadline(time.Now().Add(writeTimeout))
+	err := conn.Handshake()
+	conn.SetDeadline(time.Time{})
+	if err != nil {
+		return "", err
+	}
+	return keyIdentity(conn.ConnectionState().PeerCertificates[0].PublicKey)
+}
+
+// sniffedConn is a connection whose first bytes were peeked at
+type sniffedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *sniffedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -1,245 +0,0 @@
-package main
-
-import (
-	"bytes"
-	"encoding/hex"
-	"log"
-
-	"github.com/dgraph-io/badger"
-)
-
-var (
-	utxoPrefix   = []byte("utxo-")
-	prefixLength = len(utxoPrefix)
-)
-
-type UTXOSet struct {
-	Blockchain *BlockChain
-}
-
-const collectSize = 100000
-
-func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
-	unspentOuts := make(map[string][]int)
-	accumulated := 0
-	db := u.Blockchain.Database
-
-	err := db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-
-		it := txn.NewIterator(opts)
-		defer it.Close()
-
-		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
-			item := it.Item()
-			k := item.Key()
-			v, err := item.Value()
-			if err != nil {
-				log.Panic(err)
-			}
-			k = bytes.TrimPrefix(k, utxoPrefix)
-			txId := hex.EncodeToString(k)
-			outs := DeserializeOutputs(v)
-
-			for outIndex, out := range outs.Outputs {
-				if out.KeyLocked(pubKeyHash) && accumulated < amount {
-					accumulated += out.Amount
-					unspentOuts[txId] = append(unspentOuts[txId], outIndex)
-				}
-			}
-		}
-		return nil
-	})
-	if err != nil {
-		log.Panic(err)
-	}
-	return accumulated, unspentOuts
-}
-
-func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TxOutput {
-	var UTXOs []TxOutput
-
-	db := u.Blockchain.Database
-
-	err := db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-
-		it := txn.NewIterator(opts)
-		defer it.Close()
-
-		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
-			item := it.Item()
-			v, err := item.Value()
-			if err != nil {
-				log.Panic(err)
-			}
-			outs := DeserializeOutputs(v)
-
-			for _, out := range outs.Outputs {
-				if out.KeyLocked(pubKeyHash) {
-					UTXOs = append(UTXOs, out)
-				}
-			}
-		}
-
-		return nil
-	})
-	if err != nil {
-		log.Panic(err)
-	}
-
-	return UTXOs
-}
-
-func (u UTXOSet) CountTransactions() int {
-	db := u.Blockchain.Database
-	counter := 0
-
-	err := db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
-			counter++
-		}
-
-		return nil
-	})
-
-	if err != nil {
-		log.Panic(err)
-	}
-
-	return counter
-}
-
-func (u UTXOSet) Reindex() {
-	db := u.Blockchain.Database
-
-	u.DeleteByPrefix(utxoPrefix)
-
-	UTXO := u.Blockchain.FindUTXO()
-
-	err := db.Update(func(txn *badger.Txn) error {
-		for txId, outs := range UTXO {
-			key, err := hex.DecodeString(txId)
-			if err != nil {
-				return err
-			}
-			key = append(utxoPrefix, key...)
-
-			err = txn.Set(key, outs.Serialize())
-			if err != nil {
-				log.Panic(err)
-			}
-		}
-
-		return nil
-	})
-	if err != nil {
-		log.Panic(err)
-	}
-}
-
-func (u *UTXOSet) Update(block *Block) {
-	db := u.Blockchain.Database
-
-	err := db.Update(func(txn *badger.Txn) error {
-		for _, tx := range block.Transactions {
-			if tx.IsCoinbase() == false {
-				for _, in := range tx.TxInputs {
-					updatedOuts := TxOutputs{}
-					inID := append(utxoPrefix, in.Id...)
-					item, err := txn.Get(inID)
-					if err != nil {
-						log.Panic(err)
-					}
-					v, err := item.Value()
-					if err != nil {
-						log.Panic(err)
-					}
-
-					outs := DeserializeOutputs(v)
-
-					for outIdx, out := range outs.Outputs {
-						if outIdx != in.OutIndex {
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-						}
-					}
-
-					if len(updatedOuts.Outputs) == 0 {
-						if err := txn.Delete(inID); err != nil {
-							log.Panic(err)
-						}
-
-					} else {
-						if err := txn.Set(inID, updatedOuts.Serialize()); err != nil {
-							log.Panic(err)
-						}
-					}
-				}
-			}
-
-			newOutputs := TxOutputs{}
-			for _, out := range tx.TxOutputs {
-				newOutputs.Outputs = append(newOutputs.Outputs, out)
-			}
-
-			txID := append(utxoPrefix, tx.Id...)
-			if err := txn.Set(txID, newOutputs.Serialize()); err != nil {
-				log.Panic(err)
-			}
-		}
-
-		return nil
-	})
-
-	if err != nil {
-		log.Panic(err)
-	}
-}
-
-func (u *UTXOSet) DeleteByPrefix(prefix []byte) {
-	deleteKeys := func(keysForDelete [][]byte) error {
-		if err := u.Blockchain.Database.Update(func(txn *badger.Txn) error {
-			for _, key := range keysForDelete {
-				if err := txn.Delete(key); err != nil {
-					return err
-				}
-			}
-			return nil
-		}); err != nil {
-			return err
-		}
-		return nil
-	}
-
-	u.Blockchain.Database.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.PrefetchValues = false
-		it := txn.NewIterator(opts)
-		defer it.Close()
-
-		keysForDelete := make([][]byte, 0, collectSize)
-		keysCollected := 0
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			key := it.Item().KeyCopy(nil)
-			keysForDelete = append(keysForDelete, key)
-			keysCollected++
-			if keysCollected == collectSize {
-				if err := deleteKeys(keysForDelete); err != nil {
-					log.Panic(err)
-				}
-				keysForDelete = make([][]byte, 0, collectSize)
-				keysCollected = 0
-			}
-		}
-		if keysCollected > 0 {
-			if err := deleteKeys(keysForDelete); err != nil {
-				log.Panic(err)
-			}
-		}
-		return nil
-	})
-}
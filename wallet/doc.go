@@ -0,0 +1,5 @@
+// Package wallet keeps the keys of a node. Wallet files are named, locked
+// while written and backed up on every change, and hold change addresses,
+// labels and contacts next to the keys. The package also builds and signs
+// payments, signs messages and splits wallet backups into Shamir shares.
+package wallet
@@ -0,0 +1,159 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"minhlpc/build_blockchain/core"
+)
+
+const maxLabelLength = 64
+
+// Contact is an entry of the address book
+type Contact struct {
+	Address string `json:"address"`
+	Label   string `json:"label"`
+}
+
+func validateLabel(label string) error {
+	if len(label) > maxLabelLength {
+		return fmt.Errorf("label is longer than %d characters", maxLabelLength)
+	}
+	if strings.ContainsAny(label, "\n\r\t") {
+		return errors.New("label may not contain line breaks or tabs")
+	}
+	return nil
+}
+
+// SetLabel names one of our own addresses, an empty label removes it
+func (ws *Wallets) SetLabel(address, label string) error {
+	if _, ok := ws.Wallets[address]; !ok {
+		return fmt.Errorf("address %s is not in wallet %s", address, ws.Name())
+	}
+	if err := validateLabel(label); err != nil {
+		return err
+	}
+
+	if label == "" {
+		delete(ws.Labels, address)
+	} else {
+		ws.Labels[address] = label
+	}
+	return nil
+}
+
+// SetContact adds or renames a counterparty in the address book, an empty
+// label removes it
+func (ws *Wallets) SetContact(address, label string) error {
+	if _, err := core.DecodeAddress(address); err != nil {
+		return err
+	}
+	if err := validateLabel(label); err != nil {
+		return err
+	}
+
+	if label == "" {
+		delete(ws.Contacts, address)
+	} else {
+		ws.Contacts[address] = label
+	}
+	return nil
+}
+
+func (ws *Wallets) RemoveContact(address string) error {
+	if _, ok := ws.Contacts[address]; !ok {
+		return fmt.Errorf("address %s is not in the address book", address)
+	}
+	delete(ws.Contacts, address)
+	return nil
+}
+
+// GetContacts lists the address book sorted by label
+func (ws *Wallets) GetContacts() []Contact {
+	var contacts []Contact
+	for address, label := range ws.Contacts {
+		contacts = append(contacts, Contact{address, label})
+	}
+	sort.Slice(contacts, func(i, j int) bool {
+		if contacts[i].Label != contacts[j].Label {
+			return contacts[i].Label < contacts[j].Label
+		}
+		return contacts[i].Address < contacts[j].Address
+	})
+
+	return contacts
+}
+
+// GetLabel finds the label of an address among our own labels first and then
+// in the address book. The same key has a Base58 and a bech32 address, so
+// addresses are compared by public key hash when there is no exact match.
+func (ws *Wallets) GetLabel(address string) string {
+	if label, ok := ws.Labels[address]; ok {
+		return label
+	}
+	if label, ok := ws.Contacts[address]; ok {
+		return label
+	}
+
+	pubKeyHash, err := core.DecodeAddress(address)
+	if err != nil {
+		return ""
+	}
+	for _, labels := range []map[string]string{ws.Labels, ws.Contacts} {
+		for labeled, label := range labels {
+			if other, err := core.DecodeAddress(labeled); err == nil && bytes.Equal(other, pubKeyHash) {
+				return label
+			}
+		}
+	}
+	return ""
+}
+
+// LabelHistory writes the addresses of history entries that belong to the
+// wallet or its contacts as the wallet knows them, so a bech32 address is not
+// listed in Base58, and fills in their labels
+func (ws *Wallets) LabelHistory(history []core.TxHistoryEntry) []core.TxHistoryEntry {
+	known := ws.knownAddresses()
+	for i, entry := range history {
+		if address, ok := known[string(entry.PubKeyHash)]; ok {
+			history[i].Address = address
+		}
+		for j, pubKeyHash := range entry.CounterpartyHashes {
+			if address, ok := known[string(pubKeyHash)]; ok && j < len(entry.Counterparties) {
+				history[i].Counterparties[j] = address
+			}
+		}
+
+		history[i].Label = ws.GetLabel(history[i].Address)
+		for _, counterparty := range history[i].Counterparties {
+			if label := ws.GetLabel(counterparty); label != "" {
+				if history[i].CounterpartyLabels == nil {
+					history[i].CounterpartyLabels = make(map[string]string)
+				}
+				history[i].CounterpartyLabels[counterparty] = label
+			}
+		}
+	}
+	return history
+}
+
+// knownAddresses maps the public key hashes of the contacts and of the wallet
+// addresses to the address they were saved under, our own addresses win
+func (ws *Wallets) knownAddresses() map[string]string {
+	known := make(map[string]string)
+	addresses := make([]string, 0, len(ws.Contacts))
+	for address := range ws.Contacts {
+		addresses = append(addresses, address)
+	}
+	addresses = append(addresses, ws.GetAllAddresses()...)
+
+	for _, address := range addresses {
+		if pubKeyHash, err := core.DecodeAddress(address); err == nil {
+			known[string(pubKeyHash)] = address
+		}
+	}
+	return known
+}
@@ -0,0 +1,86 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log"
+	"math/big"
+
+	"minhlpc/build_blockchain/core"
+)
+
+// the prefix keeps a signed message from ever being a valid transaction hash
+const messagePrefix = "Blockchain Signed Message:\n"
+
+// signatureScalarLength is the length of r and s in a signature, and of each
+// coordinate of the public key
+const signatureScalarLength = 32
+
+func MessageHash(message string) []byte {
+	first := sha256.Sum256(append([]byte(messagePrefix), message...))
+	second := sha256.Sum256(first[:])
+	return second[:]
+}
+
+// SignMessage signs message with the wallet key. The signature embeds the
+// public key so the verifier can check it hashes to the address:
+// key length | x | y | r | s, base64 encoded. The coordinates are written at
+// their full length, the key of the wallet drops their leading zeros.
+func SignMessage(w *Wallet, message string) string {
+	r, s, err := ecdsa.Sign(rand.Reader, &w.PrivateKey, MessageHash(message))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	signature := []byte{2 * signatureScalarLength}
+	signature = append(signature, w.PrivateKey.X.FillBytes(make([]byte, signatureScalarLength))...)
+	signature = append(signature, w.PrivateKey.Y.FillBytes(make([]byte, signatureScalarLength))...)
+	signature = append(signature, r.FillBytes(make([]byte, signatureScalarLength))...)
+	signature = append(signature, s.FillBytes(make([]byte, signatureScalarLength))...)
+
+	return base64.StdEncoding.EncodeToString(signature)
+}
+
+// VerifyMessage checks that signature was made over message by the key behind
+// address
+func VerifyMessage(address, signature, message string) (bool, error) {
+	decoded, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false, errors.New("signature is not base64")
+	}
+	if len(decoded) < 1 {
+		return false, errors.New("signature is malformed")
+	}
+	if int(decoded[0]) != 2*signatureScalarLength || len(decoded) != 1+4*signatureScalarLength {
+		return false, errors.New("signature is malformed")
+	}
+	fields := decoded[1:]
+	x := new(big.Int).SetBytes(fields[:signatureScalarLength])
+	y := new(big.Int).SetBytes(fields[signatureScalarLength : 2*signatureScalarLength])
+	rs := fields[2*signatureScalarLength:]
+
+	// the address hashes the key as the wallet stores it
+	pubKeyHash, err := core.DecodeAddress(address)
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(core.PublicKeyHash(append(x.Bytes(), y.Bytes()...)), pubKeyHash) {
+		return false, nil
+	}
+
+	r := new(big.Int).SetBytes(rs[:signatureScalarLength])
+	s := new(big.Int).SetBytes(rs[signatureScalarLength:])
+
+	curve := elliptic.P256()
+	if !curve.IsOnCurve(x, y) {
+		return false, errors.New("signature has an invalid public key")
+	}
+	rawPubKey := ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+
+	return ecdsa.Verify(&rawPubKey, MessageHash(message), r, s), nil
+}
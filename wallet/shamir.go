@@ -0,0 +1,141 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// Shamir secret sharing over GF(256). Every byte of the secret is the
+// constant term of its own random polynomial of degree threshold-1, a share
+// is the value of all polynomials at one x. Any threshold shares recover the
+// secret by interpolating at x = 0, fewer tell nothing about it.
+
+const maxShares = 255
+
+var gfExp, gfLog = gfTables()
+
+// gfTables builds exponent and logarithm tables for generator 3 of the AES
+// field, x^8 + x^4 + x^3 + x + 1. The exponent table is doubled so products
+// of logarithms need no reduction.
+func gfTables() ([510]byte, [256]byte) {
+	var exp [510]byte
+	var logarithm [256]byte
+
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		exp[i] = x
+		exp[i+255] = x
+		logarithm[x] = byte(i)
+
+		// multiply by 3, that is x*2 + x
+		double := x << 1
+		if x&0x80 != 0 {
+			double ^= 0x1b
+		}
+		x ^= double
+	}
+	return exp, logarithm
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if b == 0 {
+		panic("division by zero in GF(256)")
+	}
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// SplitSecret splits secret into n shares of which any threshold recover it.
+// Each share is its x coordinate followed by one y byte per secret byte.
+func SplitSecret(secret []byte, n, threshold int) ([][]byte, error) {
+	if threshold < 2 {
+		return nil, errors.New("threshold must be at least 2")
+	}
+	if n < threshold {
+		return nil, errors.New("there must be at least as many shares as the threshold")
+	}
+	if n > maxShares {
+		return nil, fmt.Errorf("at most %d shares are supported", maxShares)
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("secret is empty")
+	}
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][0] = byte(i + 1)
+	}
+
+	coefficients := make([]byte, threshold)
+	for pos, b := range secret {
+		coefficients[0] = b
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, err
+		}
+
+		for _, share := range shares {
+			x := share[0]
+			// Horner's rule from the highest coefficient down
+			y := byte(0)
+			for c := threshold - 1; c >= 0; c-- {
+				y = gfMul(y, x) ^ coefficients[c]
+			}
+			share[pos+1] = y
+		}
+	}
+
+	return shares, nil
+}
+
+// CombineShares recovers the secret from shares made by SplitSecret. With
+// fewer shares than the threshold the result is random bytes, callers that
+// need to know check the secret themselves.
+func CombineShares(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, errors.New("at least 2 shares are needed")
+	}
+
+	length := len(shares[0])
+	seen := make(map[byte]bool)
+	for _, share := range shares {
+		if len(share) < 2 || len(share) != length {
+			return nil, errors.New("shares have different lengths")
+		}
+		if share[0] == 0 || seen[share[0]] {
+			return nil, fmt.Errorf("share %d is invalid or given twice", share[0])
+		}
+		seen[share[0]] = true
+	}
+
+	// the Lagrange basis polynomials evaluated at 0
+	basis := make([]byte, len(shares))
+	for i, share := range shares {
+		basis[i] = 1
+		for j, other := range shares {
+			if i != j {
+				// (0 - xj) / (xi - xj), subtraction is xor
+				basis[i] = gfMul(basis[i], gfDiv(other[0], share[0]^other[0]))
+			}
+		}
+	}
+
+	secret := make([]byte, length-1)
+	for pos := range secret {
+		for i, share := range shares {
+			secret[pos] ^= gfMul(share[pos+1], basis[i])
+		}
+	}
+
+	return secret, nil
+}
@@ -0,0 +1,98 @@
+package wallet
+
+import (
+	"bytes"
+	"testing"
+)
+
+// subsets lists every subset of shares, by bit mask
+func subsets(shares [][]byte) [][][]byte {
+	var all [][][]byte
+	for mask := 1; mask < 1<<len(shares); mask++ {
+		var subset [][]byte
+		for i, share := range shares {
+			if mask&(1<<i) != 0 {
+				subset = append(subset, share)
+			}
+		}
+		all = append(all, subset)
+	}
+	return all
+}
+
+func TestSplitSecretThresholds(t *testing.T) {
+	// a 32 byte secret comes out of too few shares by chance once in 2^256
+	secret := bytes.Repeat([]byte{0x5a, 0x00, 0xff, 0x13}, 8)
+
+	for n := 2; n <= 6; n++ {
+		for k := 2; k <= n; k++ {
+			shares, err := SplitSecret(secret, n, k)
+			if err != nil {
+				t.Fatalf("%d of %d: %s", k, n, err)
+			}
+			if len(shares) != n {
+				t.Fatalf("%d of %d: got %d shares", k, n, len(shares))
+			}
+
+			for _, subset := range subsets(shares) {
+				recovered, err := CombineShares(subset)
+				if len(subset) >= k {
+					if err != nil {
+						t.Fatalf("%d of %d, %d shares: %s", k, n, len(subset), err)
+					}
+					if !bytes.Equal(recovered, secret) {
+						t.Fatalf("%d of %d, %d shares: secret is not recovered", k, n, len(subset))
+					}
+					continue
+				}
+				if err == nil && bytes.Equal(recovered, secret) {
+					t.Fatalf("%d of %d: %d shares recover the secret", k, n, len(subset))
+				}
+			}
+		}
+	}
+}
+
+func TestSplitSecretRejectsInvalidInput(t *testing.T) {
+	secret := []byte("secret")
+	tests := []struct {
+		name         string
+		secret       []byte
+		n, threshold int
+	}{
+		{"threshold over shares", secret, 3, 4},
+		{"threshold of 1", secret, 3, 1},
+		{"threshold of 0", secret, 3, 0},
+		{"too many shares", secret, maxShares + 1, 2},
+		{"empty secret", nil, 3, 2},
+	}
+	for _, test := range tests {
+		if _, err := SplitSecret(test.secret, test.n, test.threshold); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+}
+
+func TestCombineSharesRejectsInvalidShares(t *testing.T) {
+	shares, err := SplitSecret([]byte("secret"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		shares [][]byte
+	}{
+		{"single share", shares[:1]},
+		{"duplicate index", [][]byte{shares[0], shares[0]}},
+		{"same index other bytes", [][]byte{shares[1], append([]byte{shares[1][0]}, shares[2][1:]...)}},
+		{"mismatched lengths", [][]byte{shares[0], shares[1][:len(shares[1])-1]}},
+		{"index 0", [][]byte{shares[0], append([]byte{0}, shares[1][1:]...)}},
+		{"share without data", [][]byte{shares[0][:1], shares[1][:1]}},
+	}
+	for _, test := range tests {
+		if _, err := CombineShares(test.shares); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+}
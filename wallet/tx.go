@@ -0,0 +1,84 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"fmt"
+
+	"minhlpc/build_blockchain/core"
+)
+
+// TxOptions adds the keys of earlier change to the coin selection options of
+// a payment
+type TxOptions struct {
+	core.TxOptions
+	// ChangeWallets hold earlier change of the sender, their outputs can be
+	// spent together with the sender's own
+	ChangeWallets []*Wallet
+}
+
+func CreateTx(w *Wallet, to string, amount int, UTXO *core.UTXOSet, opts TxOptions) (*core.Transaction, error) {
+	return CreateMultiTx(w, map[string]int{to: amount}, UTXO, opts)
+}
+
+// CreateMultiTx pays every recipient address its amount from one transaction,
+// with a single change output
+func CreateMultiTx(w *Wallet, recipients map[string]int, UTXO *core.UTXOSet, opts TxOptions) (*core.Transaction, error) {
+	var pubKeyHashes [][]byte
+	owners := make(map[string]*Wallet)
+	for _, wallet := range append([]*Wallet{w}, opts.ChangeWallets...) {
+		pubKeyHash := core.PublicKeyHash(wallet.PublicKey)
+		pubKeyHashes = append(pubKeyHashes, pubKeyHash)
+		owners[hex.EncodeToString(pubKeyHash)] = wallet
+	}
+
+	tx, spent, err := core.BuildTx(pubKeyHashes, recipients, fmt.Sprintf("%s", w.Address()), UTXO, opts.TxOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	privKeys := make(map[string]ecdsa.PrivateKey)
+	for inId, out := range spent {
+		owner := owners[hex.EncodeToString(out.Output.PublicKey)]
+		tx.TxInputs[inId].PublicKey = owner.PublicKey
+		privKeys[hex.EncodeToString(owner.PublicKey)] = owner.PrivateKey
+	}
+	tx.Id = tx.Hash()
+
+	if err := UTXO.Blockchain.SignTransactionWithKeys(&tx, privKeys); err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
+// SignRawTx fills in the public keys of the inputs of raw from the wallets
+// and signs them. It needs no blockchain, everything is taken from the raw
+// transaction.
+func (ws *Wallets) SignRawTx(raw *core.RawTx) error {
+	if len(raw.Tx.TxInputs) == 0 {
+		return fmt.Errorf("%w: raw transaction has no inputs", core.ErrInvalidTx)
+	}
+	if err := raw.CheckPrevTxs(); err != nil {
+		return err
+	}
+
+	owners := make(map[string]*Wallet)
+	for _, wallet := range ws.Wallets {
+		owners[hex.EncodeToString(core.PublicKeyHash(wallet.PublicKey))] = wallet
+	}
+
+	privKeys := make(map[string]ecdsa.PrivateKey)
+	for inId, in := range raw.Tx.TxInputs {
+		out := raw.PrevTxs[hex.EncodeToString(in.Id)].TxOutputs[in.OutIndex]
+		owner, ok := owners[hex.EncodeToString(out.PublicKey)]
+		if !ok {
+			return fmt.Errorf("%w %d in the wallet", core.ErrMissingKey, inId)
+		}
+		raw.Tx.TxInputs[inId].PublicKey = owner.PublicKey
+		raw.Tx.TxInputs[inId].Signature = nil
+		privKeys[hex.EncodeToString(owner.PublicKey)] = owner.PrivateKey
+	}
+	raw.Tx.Id = raw.Tx.Hash()
+
+	return raw.Tx.SignWithKeys(privKeys, raw.PrevTxs)
+}
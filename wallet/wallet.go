@@ -0,0 +1,83 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/gob"
+	"errors"
+	"log"
+	"math/big"
+
+	"minhlpc/build_blockchain/core"
+)
+
+// Wallet is one ECDSA P256 key pair, the address is derived from the public
+// key
+type Wallet struct {
+	PrivateKey ecdsa.PrivateKey
+	PublicKey  []byte
+}
+
+// walletData is how a Wallet is stored. The curve is always P256 and is not
+// written, gob can not encode the curve types of newer Go releases.
+type walletData struct {
+	D         []byte
+	PublicKey []byte
+}
+
+func (w Wallet) GobEncode() ([]byte, error) {
+	var content bytes.Buffer
+	err := gob.NewEncoder(&content).Encode(walletData{w.PrivateKey.D.Bytes(), w.PublicKey})
+	return content.Bytes(), err
+}
+
+func (w *Wallet) GobDecode(content []byte) error {
+	var data walletData
+	if err := gob.NewDecoder(bytes.NewReader(content)).Decode(&data); err != nil {
+		return err
+	}
+
+	curve := elliptic.P256()
+	d := new(big.Int).SetBytes(data.D)
+	if d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+		return errors.New("wallet has an invalid private key")
+	}
+	x, y := curve.ScalarBaseMult(data.D)
+
+	w.PrivateKey = ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: curve, X: x, Y: y}, D: d}
+	w.PublicKey = data.PublicKey
+	return nil
+}
+
+func CreatePair() (ecdsa.PrivateKey, []byte) {
+	curve := elliptic.P256()
+	private, err := ecdsa.GenerateKey(curve, rand.Reader)
+
+	if err != nil {
+		log.Panic(err)
+	}
+
+	pub := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+
+	return *private, pub
+
+}
+
+func MakeWallet() *Wallet {
+	private, public := CreatePair()
+	wallet := Wallet{private, public}
+
+	return &wallet
+}
+
+func (w Wallet) Address() []byte {
+	pHash := core.PublicKeyHash(w.PublicKey)
+	address := []byte(core.PubKeyHashToAddress(pHash))
+	return address
+}
+
+func (w Wallet) Bech32Address() []byte {
+	return []byte(core.PubKeyHashToBech32(core.PublicKeyHash(w.PublicKey)))
+}
@@ -0,0 +1,206 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Wallet files are never written in place. The new content goes to a
+// temporary file in the same directory which is synced and renamed over the
+// old file, so a crash leaves either the old or the new wallet on disk.
+// Writers hold an exclusive lock on <wallet file>.lock, which also keeps other
+// processes out, and every change keeps the previous file as a timestamped
+// backup.
+
+const (
+	walletBackupDir   = "wallet_backups"
+	walletBackupCount = 10
+	// fixed width and UTC, so backups sort by name in the order they were made
+	walletBackupTimeFormat = "20060102T150405.000000000Z"
+)
+
+// WriteFileAtomic replaces the file at path with data through a synced
+// temporary file and a rename, creating the directory if needed
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+func walletLockFile(path string) string {
+	return path + ".lock"
+}
+
+// walletBackupName names a backup of path made at the given time
+func walletBackupName(path string, at time.Time) string {
+	return fmt.Sprintf("%s.%s.bak", filepath.Base(path), at.UTC().Format(walletBackupTimeFormat))
+}
+
+// walletBackups lists the backups of a wallet file, oldest first
+func walletBackups(path string) []string {
+	pattern := filepath.Join(filepath.Dir(path), walletBackupDir, filepath.Base(path)+".*.bak")
+	matches, _ := filepath.Glob(pattern)
+	sort.Strings(matches)
+	return matches
+}
+
+// backupWalletFile copies the current wallet file into the backup directory
+// and drops the oldest backups beyond walletBackupCount. The caller holds the
+// lock.
+func backupWalletFile(path string) error {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	backup := filepath.Join(filepath.Dir(path), walletBackupDir, walletBackupName(path, time.Now()))
+	if err := WriteFileAtomic(backup, content, 0600); err != nil {
+		return err
+	}
+
+	backups := walletBackups(path)
+	for len(backups) > walletBackupCount {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// save writes the wallets to path, the caller holds the lock
+func (ws *Wallets) save(path string) error {
+	var content bytes.Buffer
+	if err := gob.NewEncoder(&content).Encode(ws); err != nil {
+		return err
+	}
+
+	if err := backupWalletFile(path); err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, content.Bytes(), 0600)
+}
+
+// Update reads the wallet file again under the lock, applies change and saves
+// the result. Keys that another request or process added since the wallets
+// were opened are kept, where a plain SaveFile would overwrite them.
+func (ws *Wallets) Update(nodeId string, change func() error) error {
+	path := walletFile(nodeId, ws.name)
+	unlock, err := lockFile(walletLockFile(path))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := ws.LoadFile(nodeId); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := change(); err != nil {
+		return err
+	}
+	return ws.save(path)
+}
+
+// BackupWallet copies the named wallet file to dest. When dest is a directory
+// the copy gets a timestamped name inside it. It returns the path written.
+func BackupWallet(nodeId, name, dest string) (string, error) {
+	path := walletFile(nodeId, name)
+	unlock, err := lockFile(walletLockFile(path))
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", ErrWalletNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if info, err := os.Stat(dest); err == nil && info.IsDir() {
+		dest = filepath.Join(dest, walletBackupName(path, time.Now()))
+	}
+	if err := WriteFileAtomic(dest, content, 0600); err != nil {
+		return "", err
+	}
+	return dest, nil
+}
+
+// legacyWallets is the layout of wallet files written before Wallet had its
+// own gob encoding, when the key was stored with its curve as an interface
+// value. Only toolchains whose P256 curve gob can handle read them.
+type legacyWallets struct {
+	Wallets  map[string]*legacyWallet
+	ChangeOf map[string]string
+	Labels   map[string]string
+	Contacts map[string]string
+}
+
+type legacyWallet struct {
+	PrivateKey ecdsa.PrivateKey
+	PublicKey  []byte
+}
+
+func decodeLegacyWallets(content []byte) (Wallets, error) {
+	var legacy legacyWallets
+	gob.Register(elliptic.P256())
+	if err := gob.NewDecoder(bytes.NewReader(content)).Decode(&legacy); err != nil {
+		return Wallets{}, err
+	}
+
+	wallets := Wallets{
+		Wallets:  make(map[string]*Wallet),
+		ChangeOf: legacy.ChangeOf,
+		Labels:   legacy.Labels,
+		Contacts: legacy.Contacts,
+	}
+	for address, w := range legacy.Wallets {
+		wallets.Wallets[address] = &Wallet{w.PrivateKey, w.PublicKey}
+	}
+	return wallets, nil
+}
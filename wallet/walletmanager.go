@@ -0,0 +1,185 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"minhlpc/build_blockchain/core"
+)
+
+// A node can hold several wallets, each in its own file. A wallet has to be
+// loaded before requests may use it, the default wallet always is. The running
+// node keeps the list of loaded wallets in its Manager and every client goes
+// through the node, so a restarted node has only the default wallet loaded.
+
+const DefaultWalletName = "default"
+
+var walletNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+var (
+	ErrWalletExists    = errors.New("wallet already exists")
+	ErrWalletNotFound  = errors.New("wallet does not exist")
+	ErrWalletNotLoaded = errors.New("wallet is not loaded")
+)
+
+func ValidateWalletName(name string) error {
+	if !walletNamePattern.MatchString(name) {
+		return fmt.Errorf("wallet name %q may only contain letters, digits, '_' and '-'", name)
+	}
+	return nil
+}
+
+func walletExists(nodeId, name string) bool {
+	_, err := os.Stat(walletFile(nodeId, name))
+	return err == nil
+}
+
+// GetWalletFileNames lists every wallet of the node that has a file
+func GetWalletFileNames(nodeId string) []string {
+	var names []string
+
+	if walletExists(nodeId, DefaultWalletName) {
+		names = append(names, DefaultWalletName)
+	}
+
+	prefix := fmt.Sprintf("wallets_%s_", nodeId)
+	matches, _ := filepath.Glob(core.NetPath(".", prefix+"*.data"))
+	for _, match := range matches {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(match), prefix), ".data")
+		if ValidateWalletName(name) == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Manager knows which wallets of a node are loaded
+type Manager struct {
+	nodeId string
+	lock   sync.Mutex
+	loaded map[string]bool
+}
+
+func NewManager(nodeId string) *Manager {
+	return &Manager{nodeId: nodeId, loaded: map[string]bool{DefaultWalletName: true}}
+}
+
+// LoadedNames lists the loaded wallets, default first
+func (m *Manager) LoadedNames() []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var loaded []string
+	for name := range m.loaded {
+		if name != DefaultWalletName {
+			loaded = append(loaded, name)
+		}
+	}
+	sort.Strings(loaded)
+
+	return append([]string{DefaultWalletName}, loaded...)
+}
+
+func (m *Manager) IsLoaded(name string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.loaded[name]
+}
+
+// Create makes the named wallet with a first address and loads it
+func (m *Manager) Create(name string, bech32 bool) (*Wallets, string, error) {
+	if err := ValidateWalletName(name); err != nil {
+		return nil, "", err
+	}
+	if walletExists(m.nodeId, name) {
+		return nil, "", ErrWalletExists
+	}
+
+	wallets, _ := OpenWallets(m.nodeId, name)
+	var address string
+	err := wallets.Update(m.nodeId, func() error {
+		// checked again under the lock, another request may have won
+		if len(wallets.Wallets) > 0 {
+			return ErrWalletExists
+		}
+		if bech32 {
+			address = wallets.AddBech32Wallet()
+		} else {
+			address = wallets.AddWallets()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := m.Load(name); err != nil {
+		return nil, "", err
+	}
+	return wallets, address, nil
+}
+
+func (m *Manager) Load(name string) error {
+	if err := ValidateWalletName(name); err != nil {
+		return err
+	}
+	if !walletExists(m.nodeId, name) {
+		return ErrWalletNotFound
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.loaded[name] = true
+	return nil
+}
+
+func (m *Manager) Unload(name string) error {
+	if name == DefaultWalletName {
+		return errors.New("the default wallet can not be unloaded")
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if !m.loaded[name] {
+		return ErrWalletNotLoaded
+	}
+	delete(m.loaded, name)
+	return nil
+}
+
+// Select opens the wallet picked by a -wallet flag or wallet request field,
+// the empty selector is the default wallet
+func (m *Manager) Select(name string) (*Wallets, error) {
+	if name == "" {
+		name = DefaultWalletName
+	}
+	if !m.IsLoaded(name) {
+		return nil, fmt.Errorf("%s: %w", name, ErrWalletNotLoaded)
+	}
+
+	wallets, err := OpenWallets(m.nodeId, name)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return wallets, nil
+}
+
+// Recover recombines shares into the named wallet, which must not exist yet,
+// and loads it
+func (m *Manager) Recover(name string, shares []BackupShare) (*Wallets, error) {
+	if err := RecoverWallet(m.nodeId, name, shares); err != nil {
+		return nil, err
+	}
+	if err := m.Load(name); err != nil {
+		return nil, err
+	}
+	return OpenWallets(m.nodeId, name)
+}
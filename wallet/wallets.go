@@ -0,0 +1,203 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"minhlpc/build_blockchain/core"
+)
+
+type Wallets struct {
+	Wallets map[string]*Wallet
+	// ChangeOf maps every change address to the address it is change for
+	ChangeOf map[string]string
+	// Labels names our own addresses, Contacts is the address book of
+	// counterparties, both map an address to its label
+	Labels   map[string]string
+	Contacts map[string]string
+
+	// name selects the wallet file of the node, it is not stored in the file
+	name string
+}
+
+// walletFile is wallets_<NODE_ID>.data for the default wallet and
+// wallets_<NODE_ID>_<name>.data for named ones
+func walletFile(nodeId, name string) string {
+	if name == "" || name == DefaultWalletName {
+		return core.NetPath(".", fmt.Sprintf("wallets_%s.data", nodeId))
+	}
+	return core.NetPath(".", fmt.Sprintf("wallets_%s_%s.data", nodeId, name))
+}
+
+func (ws *Wallets) Name() string {
+	if ws.name == "" {
+		return DefaultWalletName
+	}
+	return ws.name
+}
+
+// SaveFile replaces the wallet file with ws. Use Update for changes, SaveFile
+// drops keys that were added to the file after ws was opened.
+func (ws *Wallets) SaveFile(nodeId string) error {
+	walletFile := walletFile(nodeId, ws.name)
+	unlock, err := lockFile(walletLockFile(walletFile))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return ws.save(walletFile)
+}
+
+func (ws *Wallets) LoadFile(nodeId string) error {
+	walletFile := walletFile(nodeId, ws.name)
+	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
+		return err
+	}
+	content, err := ioutil.ReadFile(walletFile)
+	if err != nil {
+		return err
+	}
+	wallets, err := decodeWallets(content)
+	if err != nil {
+		return fmt.Errorf("%s: %w", walletFile, err)
+	}
+
+	ws.Wallets = wallets.Wallets
+	if wallets.ChangeOf != nil {
+		ws.ChangeOf = wallets.ChangeOf
+	}
+	if wallets.Labels != nil {
+		ws.Labels = wallets.Labels
+	}
+	if wallets.Contacts != nil {
+		ws.Contacts = wallets.Contacts
+	}
+	return nil
+}
+
+// decodeWallets reads the content of a wallet file, falling back to the
+// layout of older files
+func decodeWallets(content []byte) (Wallets, error) {
+	var wallets Wallets
+	err := gob.NewDecoder(bytes.NewReader(content)).Decode(&wallets)
+	if err != nil {
+		if legacy, legacyErr := decodeLegacyWallets(content); legacyErr == nil {
+			return legacy, nil
+		}
+	}
+	return wallets, err
+}
+
+// CreateWallets opens the default wallet of the node
+func CreateWallets(nodeId string) (*Wallets, error) {
+	return OpenWallets(nodeId, DefaultWalletName)
+}
+
+// OpenWallets opens the named wallet of the node. Like CreateWallets the
+// returned wallet is usable, and empty, when its file does not exist yet.
+func OpenWallets(nodeId, name string) (*Wallets, error) {
+	wallets := Wallets{}
+	wallets.Wallets = make(map[string]*Wallet)
+	wallets.ChangeOf = make(map[string]string)
+	wallets.Labels = make(map[string]string)
+	wallets.Contacts = make(map[string]string)
+	wallets.name = name
+
+	err := wallets.LoadFile(nodeId)
+	return &wallets, err
+}
+
+func (ws *Wallets) GetAllAddresses() []string {
+	var addresses []string
+	for address := range ws.Wallets {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	return addresses
+}
+
+func (ws *Wallets) AddWallets() string {
+	wallet := MakeWallet()
+	address := fmt.Sprintf("%s", wallet.Address())
+	ws.Wallets[address] = wallet
+
+	return address
+}
+
+// AddBech32Wallet is AddWallets with the address in bech32 format
+func (ws *Wallets) AddBech32Wallet() string {
+	wallet := MakeWallet()
+	address := fmt.Sprintf("%s", wallet.Bech32Address())
+	ws.Wallets[address] = wallet
+
+	return address
+}
+
+func (ws Wallets) GetWallet(address string) Wallet {
+	return *ws.Wallets[address]
+}
+
+// AddChangeWallet creates a fresh address to receive change of owner. Change
+// of a change address is booked on the original owner.
+func (ws *Wallets) AddChangeWallet(owner string) string {
+	if root, ok := ws.ChangeOf[owner]; ok {
+		owner = root
+	}
+
+	var address string
+	if core.IsBech32Address(owner) {
+		address = ws.AddBech32Wallet()
+	} else {
+		address = ws.AddWallets()
+	}
+	ws.ChangeOf[address] = owner
+
+	return address
+}
+
+// GetChangeAddresses lists the change addresses of owner, sorted
+func (ws *Wallets) GetChangeAddresses(owner string) []string {
+	var addresses []string
+	for address, of := range ws.ChangeOf {
+		if of == owner {
+			addresses = append(addresses, address)
+		}
+	}
+	sort.Strings(addresses)
+
+	return addresses
+}
+
+// WithChange lets a payment from address also spend its earlier change and
+// sends new change to a fresh change address. The key of that address is
+// saved in the wallets before the payment is built, so change is never paid
+// to a key that is lost, an address left unused costs nothing. With reuse the
+// change goes back to address as before.
+func (ws *Wallets) WithChange(address, nodeId string, reuse bool, opts TxOptions) (TxOptions, error) {
+	for _, change := range ws.GetChangeAddresses(address) {
+		opts.ChangeWallets = append(opts.ChangeWallets, ws.Wallets[change])
+	}
+	if reuse {
+		return opts, nil
+	}
+
+	var change string
+	err := ws.Update(nodeId, func() error {
+		change = ws.AddChangeWallet(address)
+		return nil
+	})
+	if err != nil {
+		return opts, err
+	}
+	opts.NewChange = func() (string, error) {
+		return change, nil
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,159 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"minhlpc/build_blockchain/core"
+)
+
+// A wallet backup is split into shares of the whole wallet file, the wallet
+// has no master seed its keys derive from. A share is hex text:
+// version | set id | threshold | x | y bytes | checksum.
+// The set id keeps shares of different splits apart, the checksum catches
+// typos in a single share and the secret carries its own checksum, so
+// recombining shares of a set below the threshold is an error instead of a
+// corrupt wallet.
+
+const backupShareVersion = 1
+
+const backupShareHeaderLength = 1 + 4 + 1
+
+var (
+	ErrShareChecksum  = errors.New("share checksum does not match, it was mistyped or damaged")
+	ErrShareFormat    = errors.New("share is malformed")
+	ErrShareSet       = errors.New("shares belong to different backups")
+	ErrShareThreshold = errors.New("not enough shares to recover the backup")
+)
+
+type BackupShare struct {
+	SetID     [4]byte
+	Threshold int
+	// Share is the x coordinate followed by the y bytes, as SplitSecret
+	// returns it
+	Share []byte
+}
+
+func (s BackupShare) X() int {
+	return int(s.Share[0])
+}
+
+func (s BackupShare) String() string {
+	payload := []byte{backupShareVersion}
+	payload = append(payload, s.SetID[:]...)
+	payload = append(payload, byte(s.Threshold))
+	payload = append(payload, s.Share...)
+	payload = append(payload, core.CheckSum(payload)...)
+
+	return hex.EncodeToString(payload)
+}
+
+func ParseBackupShare(text string) (BackupShare, error) {
+	payload, err := hex.DecodeString(strings.TrimSpace(text))
+	if err != nil || len(payload) < backupShareHeaderLength+2+core.ChecksumLength {
+		return BackupShare{}, ErrShareFormat
+	}
+
+	body := payload[:len(payload)-core.ChecksumLength]
+	if !bytes.Equal(core.CheckSum(body), payload[len(body):]) {
+		return BackupShare{}, ErrShareChecksum
+	}
+	if body[0] != backupShareVersion {
+		return BackupShare{}, fmt.Errorf("share version %d is not supported", body[0])
+	}
+
+	var share BackupShare
+	copy(share.SetID[:], body[1:5])
+	share.Threshold = int(body[5])
+	share.Share = body[backupShareHeaderLength:]
+	return share, nil
+}
+
+// SplitWallet splits the named wallet file into n shares, threshold of which
+// recover it
+func SplitWallet(nodeId, name string, n, threshold int) ([]BackupShare, error) {
+	path := walletFile(nodeId, name)
+	unlock, err := lockFile(walletLockFile(path))
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(path)
+	unlock()
+	if os.IsNotExist(err) {
+		return nil, ErrWalletNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	secret := append(core.CheckSum(content), content...)
+	raw, err := SplitSecret(secret, n, threshold)
+	if err != nil {
+		return nil, err
+	}
+
+	var setID [4]byte
+	if _, err := rand.Read(setID[:]); err != nil {
+		return nil, err
+	}
+
+	shares := make([]BackupShare, len(raw))
+	for i, share := range raw {
+		shares[i] = BackupShare{setID, threshold, share}
+	}
+	return shares, nil
+}
+
+// RecoverWallet recombines shares into the wallet file of the named wallet,
+// which must not exist yet. Manager.Recover also loads it.
+func RecoverWallet(nodeId, name string, shares []BackupShare) error {
+	if err := ValidateWalletName(name); err != nil {
+		return err
+	}
+	if len(shares) == 0 {
+		return ErrShareThreshold
+	}
+
+	raw := make([][]byte, len(shares))
+	for i, share := range shares {
+		if share.SetID != shares[0].SetID || share.Threshold != shares[0].Threshold {
+			return ErrShareSet
+		}
+		raw[i] = share.Share
+	}
+	if len(shares) < shares[0].Threshold {
+		return fmt.Errorf("%w: %d of %d", ErrShareThreshold, len(shares), shares[0].Threshold)
+	}
+
+	secret, err := CombineShares(raw)
+	if err != nil {
+		return err
+	}
+	if len(secret) < core.ChecksumLength || !bytes.Equal(core.CheckSum(secret[core.ChecksumLength:]), secret[:core.ChecksumLength]) {
+		return errors.New("recovered backup is corrupt, check the shares")
+	}
+	content := secret[core.ChecksumLength:]
+
+	if _, err := decodeWallets(content); err != nil {
+		return fmt.Errorf("recovered backup is not a wallet: %w", err)
+	}
+
+	path := walletFile(nodeId, name)
+	unlock, err := lockFile(walletLockFile(path))
+	if err != nil {
+		return err
+	}
+	if walletExists(nodeId, name) {
+		unlock()
+		return ErrWalletExists
+	}
+	err = WriteFileAtomic(path, content, 0600)
+	unlock()
+	return err
+}